@@ -0,0 +1,250 @@
+package consistent_cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// 批量读操作中，单笔数据的读取结果
+type GetStatus int
+
+const (
+	// 命中缓存
+	GetStatusHit GetStatus = iota + 1
+	// 缓存 miss，从 db 中读取到数据
+	GetStatusMiss
+	// 数据不存在. 可能命中了缓存中的 NullData，也可能是 db 中不存在
+	GetStatusNotExist
+)
+
+// 批量读操作. 返回结果与 objs 一一对应
+// 每笔数据分别经过读操作拦截器，调用了 next 的数据合并为一次批量读操作
+func (s *Service) MGet(ctx context.Context, objs []Object) ([]GetStatus, error) {
+	if len(s.opts.getInterceptors) == 0 || len(objs) == 0 {
+		return s.getBatch(ctx, objs)
+	}
+
+	results, errs := interceptBatch(len(objs), func(i int, next func() (GetResult, error)) (GetResult, error) {
+		return chainGet(s.opts.getInterceptors, func(ctx context.Context, obj Object) (GetResult, error) {
+			return next()
+		})(ctx, objs[i])
+	}, func(idxs []int) ([]GetResult, []error) {
+		batch := make([]Object, 0, len(idxs))
+		for _, i := range idxs {
+			batch = append(batch, objs[i])
+		}
+		results, errs := make([]GetResult, len(idxs)), make([]error, len(idxs))
+		statuses, err := s.getBatch(ctx, batch)
+		for k := range idxs {
+			if err != nil {
+				errs[k] = err
+				continue
+			}
+			results[k], errs[k] = statusResult(statuses[k])
+		}
+		return results, errs
+	})
+
+	// 拦截器返回的结果转换为批量读操作的结果，数据不存在不视为错误
+	statuses := make([]GetStatus, len(objs))
+	for i := range objs {
+		switch {
+		case errors.Is(errs[i], ErrorDataNotExist):
+			statuses[i] = GetStatusNotExist
+		case errs[i] != nil:
+			return nil, errs[i]
+		case results[i].Source == SourceDB:
+			statuses[i] = GetStatusMiss
+		default:
+			statuses[i] = GetStatusHit
+		}
+	}
+	return statuses, nil
+}
+
+// 批量读操作的单笔结果转换为读操作拦截器的结果
+func statusResult(status GetStatus) (GetResult, error) {
+	switch status {
+	case GetStatusHit:
+		return GetResult{GetInfo: GetInfo{UseCache: true}, Source: SourceCache, TTL: -1}, nil
+	case GetStatusMiss:
+		return GetResult{Source: SourceDB, TTL: -1}, nil
+	default:
+		return GetResult{TTL: -1}, ErrorDataNotExist
+	}
+}
+
+func (s *Service) getBatch(ctx context.Context, objs []Object) ([]GetStatus, error) {
+	statuses := make([]GetStatus, len(objs))
+	if len(objs) == 0 {
+		return statuses, nil
+	}
+
+	keys := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		keys = append(keys, obj.Key())
+	}
+
+	// 1 通过一次请求批量读取缓存. 缓存不可用时全部视为缓存 miss，且不写缓存
+	values, err := s.cache.MGet(ctx, keys)
+	unavailable := errors.Is(err, ErrorCacheUnavailable)
+	if err != nil && !unavailable {
+		return nil, err
+	}
+
+	// 2 处理命中缓存的数据，收集缓存 miss 的数据
+	misses := make([]Object, 0, len(objs))
+	missIndexes := make([]int, 0, len(objs))
+	for i, obj := range objs {
+		v, ok := values[obj.Key()]
+		// 软过期的数据同样视为缓存 miss，批量读操作不返回过期数据
+		if ok {
+			v, ok = s.openEnvelope(v)
+		}
+		if !ok {
+			misses = append(misses, obj)
+			missIndexes = append(missIndexes, i)
+			continue
+		}
+
+		// 2.1 读取到的数据为 NullData. 是为了防止缓存穿透而设置的空值
+		if v == NullData {
+			statuses[i] = GetStatusNotExist
+			s.opts.metrics.ObserveGet(GetResultNullHit)
+			continue
+		}
+		// 2.2 正常读取到数据
+		if err := obj.Read(v); err != nil {
+			return nil, err
+		}
+		statuses[i] = GetStatusHit
+		s.opts.metrics.ObserveGet(GetResultHit)
+	}
+
+	if len(misses) == 0 {
+		return statuses, nil
+	}
+
+	// 3 缓存 miss 的数据，通过一次请求批量读 db
+	exists, err := s.db.MGet(ctx, misses)
+	if err != nil {
+		return nil, err
+	}
+
+	// 4 db 中读取到的数据写入缓存，db 中不存在的数据则写入 NullData
+	entries := make([]CacheEntry, 0, len(misses))
+	missKeys := make([]string, 0, len(misses))
+	for i, obj := range misses {
+		entry := CacheEntry{Key: obj.Key(), NullData: true}
+		entry.Value, entry.ExpireSeconds = s.envelope(NullData)
+		statuses[missIndexes[i]] = GetStatusNotExist
+		result := GetResultDBMiss
+		if exists[i] {
+			v, err := obj.Write()
+			if err != nil {
+				return nil, err
+			}
+			entry.Value, entry.ExpireSeconds = s.envelope(v)
+			entry.NullData = false
+			if versioned, ok := obj.(Versioned); ok {
+				entry.Version = versioned.Version()
+			}
+			statuses[missIndexes[i]] = GetStatusMiss
+			result = GetResultMiss
+		}
+		s.opts.metrics.ObserveGet(result)
+		entries = append(entries, entry)
+		missKeys = append(missKeys, entry.Key)
+	}
+
+	// 5 租约模式下批量读流程未持有租约，缓存不可用时无法写缓存，均直接返回
+	if s.opts.leaseMilis > 0 || unavailable {
+		return statuses, nil
+	}
+
+	// 6 通过一次请求批量写缓存. 写缓存失败不影响读取结果
+	oks, err := s.cache.MPutWhenEnable(ctx, entries)
+	if err != nil {
+		s.opts.logger.Errorf("mput data into cache fail, keys: %v, err: %v", missKeys, err)
+		return statuses, nil
+	}
+	s.opts.logger.Infof("mput data into cache resp, keys: %v, oks: %v", missKeys, oks)
+	for _, ok := range oks {
+		s.opts.metrics.ObserveFill(ok)
+	}
+
+	return statuses, nil
+}
+
+// 批量写操作. 返回结果与 objs 一一对应，标识每笔数据的写入错误
+// 每笔数据分别经过写操作拦截器，调用了 next 的数据合并为一次批量写操作
+func (s *Service) MPut(ctx context.Context, objs []Object) []error {
+	if len(s.opts.putInterceptors) == 0 || len(objs) == 0 {
+		return s.putBatch(ctx, objs)
+	}
+
+	_, errs := interceptBatch(len(objs), func(i int, next func() (struct{}, error)) (struct{}, error) {
+		return struct{}{}, chainPut(s.opts.putInterceptors, func(ctx context.Context, obj Object) error {
+			_, err := next()
+			return err
+		})(ctx, objs[i])
+	}, func(idxs []int) ([]struct{}, []error) {
+		batch := make([]Object, 0, len(idxs))
+		for _, i := range idxs {
+			batch = append(batch, objs[i])
+		}
+		return make([]struct{}, len(idxs)), s.putBatch(ctx, batch)
+	})
+	return errs
+}
+
+func (s *Service) putBatch(ctx context.Context, objs []Object) []error {
+	errs := make([]error, len(objs))
+	if len(objs) == 0 {
+		return errs
+	}
+
+	// 1 写回模式下，先将缓冲区中的变更全部写入 db，避免先前追加的变更在之后写入 db，覆盖本次写入的数据
+	if err := s.Flush(ctx); err != nil {
+		return fillErrs(len(objs), err)
+	}
+
+	// 2 带有版本号的数据，删除缓存时需要留下版本号墓碑
+	keys := make([]string, 0, len(objs))
+	versions := make([]int64, len(objs))
+	for i, obj := range objs {
+		keys = append(keys, obj.Key())
+		if versioned, ok := obj.(Versioned); ok {
+			versions[i] = versioned.Version()
+		}
+	}
+
+	// 3 具体流程由一致性策略决定
+	return s.strategy.mwrite(ctx, objs, keys, versions)
+}
+
+// 异步延时批量撤销写流程 token 对 keys 的禁用
+func (s *Service) menable(ctx context.Context, keys []string, token string) {
+	go func() {
+		// 开启延时任务队列时，将写操作之前持久化的任务调整为在延时结束时到期. 由执行任务的 worker 开启链接到写流程的 span
+		if s.enqueue(enableTasks(ctx, keys, token), s.opts.enableDelayMilis) {
+			return
+		}
+
+		ctx, span := s.startLinkedSpan(ctx, "consistent_cache.MEnable", strings.Join(keys, ","))
+		// 每次执行的超时时间为 1 s，重试次数由重试策略决定
+		start := time.Now()
+		err := s.retry(ctx, func(ctx context.Context) error {
+			tctx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			return s.cache.MEnable(tctx, keys, token, s.opts.enableDelayMilis)
+		})
+		s.opts.metrics.ObserveEnable(time.Since(start), err)
+		endSpan(span, err)
+		if err != nil {
+			s.opts.logger.Errorf("menable fail, keys: %v, err: %v", keys, err)
+		}
+	}()
+}
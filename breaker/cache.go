@@ -0,0 +1,266 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/xiaoxuxiansheng/consistent_cache"
+)
+
+// 熔断器状态
+type State int
+
+const (
+	// 关闭状态，正常放行全部请求
+	StateClosed State = iota
+	// 打开状态，拒绝全部请求
+	StateOpen
+	// 半开状态，只放行有限的探测请求
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+// 带有熔断器的缓存模块，包装在其他缓存模块之前
+// 下一级缓存连续失败达到阈值后熔断器打开，期间全部调用直接返回 ErrorCacheUnavailable，不再等待下一级缓存超时
+// Service 读取缓存遇到 ErrorCacheUnavailable 时直接读 db 且不写缓存；写流程在禁用/删除缓存失败时直接返回错误，不会写 db
+type Cache struct {
+	opts *Options
+	// 下一级缓存模块
+	next consistent_cache.Cache
+
+	mu    sync.Mutex
+	state State
+	// 关闭状态下连续失败的次数
+	failures int
+	// 打开状态的开始时间
+	openedAt time.Time
+	// 半开状态下正在进行的探测请求数量
+	probes int
+}
+
+var _ consistent_cache.Cache = (*Cache)(nil)
+
+// 构造器函数
+func NewCache(next consistent_cache.Cache, opts ...Option) *Cache {
+	c := Cache{
+		opts: &Options{},
+		next: next,
+	}
+
+	for _, opt := range opts {
+		opt(c.opts)
+	}
+
+	repair(c.opts)
+	return &c
+}
+
+// 获取熔断器当前状态
+func (c *Cache) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tryHalfOpen()
+	return c.state
+}
+
+func (c *Cache) Enable(ctx context.Context, key, token string, delayMilis int64) error {
+	return run(c, func() error {
+		return c.next.Enable(ctx, key, token, delayMilis)
+	})
+}
+
+func (c *Cache) Disable(ctx context.Context, key, token string, expireSeconds int64) error {
+	return run(c, func() error {
+		return c.next.Disable(ctx, key, token, expireSeconds)
+	})
+}
+
+func (c *Cache) Get(ctx context.Context, key string) (string, error) {
+	return call(c, func() (string, error) {
+		return c.next.Get(ctx, key)
+	})
+}
+
+func (c *Cache) GetWithTTL(ctx context.Context, key string) (string, int64, error) {
+	var ttlMilis int64
+	v, err := call(c, func() (v string, err error) {
+		v, ttlMilis, err = c.next.GetWithTTL(ctx, key)
+		return v, err
+	})
+	return v, ttlMilis, err
+}
+
+func (c *Cache) GetShadow(ctx context.Context, key string) (string, error) {
+	return call(c, func() (string, error) {
+		return c.next.GetShadow(ctx, key)
+	})
+}
+
+func (c *Cache) IsEnabled(ctx context.Context, key string) (bool, error) {
+	return call(c, func() (bool, error) {
+		return c.next.IsEnabled(ctx, key)
+	})
+}
+
+func (c *Cache) GetWithLease(ctx context.Context, key, token string, leaseMilis int64) (string, bool, error) {
+	var leased bool
+	v, err := call(c, func() (v string, err error) {
+		v, leased, err = c.next.GetWithLease(ctx, key, token, leaseMilis)
+		return v, err
+	})
+	return v, leased, err
+}
+
+func (c *Cache) Del(ctx context.Context, key string, opts ...consistent_cache.CacheOption) error {
+	return run(c, func() error {
+		return c.next.Del(ctx, key, opts...)
+	})
+}
+
+func (c *Cache) PutWhenEnable(ctx context.Context, key, value string, expireSeconds int64, opts ...consistent_cache.CacheOption) (bool, error) {
+	return call(c, func() (bool, error) {
+		return c.next.PutWhenEnable(ctx, key, value, expireSeconds, opts...)
+	})
+}
+
+func (c *Cache) Set(ctx context.Context, key, value string, expireSeconds int64, opts ...consistent_cache.CacheOption) (bool, error) {
+	return call(c, func() (bool, error) {
+		return c.next.Set(ctx, key, value, expireSeconds, opts...)
+	})
+}
+
+func (c *Cache) MGet(ctx context.Context, keys []string) (map[string]string, error) {
+	return call(c, func() (map[string]string, error) {
+		return c.next.MGet(ctx, keys)
+	})
+}
+
+func (c *Cache) MPutWhenEnable(ctx context.Context, entries []consistent_cache.CacheEntry) ([]bool, error) {
+	return call(c, func() ([]bool, error) {
+		return c.next.MPutWhenEnable(ctx, entries)
+	})
+}
+
+func (c *Cache) MDisableAndDel(ctx context.Context, keys []string, token string, expireSeconds int64, opts ...consistent_cache.CacheOption) error {
+	return run(c, func() error {
+		return c.next.MDisableAndDel(ctx, keys, token, expireSeconds, opts...)
+	})
+}
+
+func (c *Cache) MEnable(ctx context.Context, keys []string, token string, delayMilis int64) error {
+	return run(c, func() error {
+		return c.next.MEnable(ctx, keys, token, delayMilis)
+	})
+}
+
+func (c *Cache) Lock(ctx context.Context, key, token string, expireMilis int64) (bool, error) {
+	return call(c, func() (bool, error) {
+		return c.next.Lock(ctx, key, token, expireMilis)
+	})
+}
+
+func (c *Cache) Unlock(ctx context.Context, key, token string) error {
+	return run(c, func() error {
+		return c.next.Unlock(ctx, key, token)
+	})
+}
+
+// 经过熔断器调用下一级缓存
+func call[T any](c *Cache, f func() (T, error)) (T, error) {
+	var zero T
+	allowed, probe := c.allow()
+	if !allowed {
+		return zero, consistent_cache.ErrorCacheUnavailable
+	}
+	v, err := f()
+	c.done(err, probe)
+	return v, err
+}
+
+func run(c *Cache, f func() error) error {
+	_, err := call(c, func() (struct{}, error) {
+		return struct{}{}, f()
+	})
+	return err
+}
+
+// 判断是否放行本次请求，以及本次请求是否为半开状态下的探测请求
+func (c *Cache) allow() (allowed, probe bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tryHalfOpen()
+	switch c.state {
+	case StateClosed:
+		return true, false
+	case StateHalfOpen:
+		if c.probes >= c.opts.halfOpenProbes {
+			return false, false
+		}
+		c.probes++
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+// 记录本次请求的结果
+func (c *Cache) done(err error, probe bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if probe && c.probes > 0 {
+		c.probes--
+	}
+
+	// 1 请求成功. 半开状态下探测成功则关闭熔断器
+	if !isFailure(err) {
+		c.failures = 0
+		if probe && c.state == StateHalfOpen {
+			c.transit(StateClosed)
+		}
+		return
+	}
+
+	// 2 请求失败. 半开状态下探测失败，或者关闭状态下连续失败达到阈值，则打开熔断器
+	c.failures++
+	if (probe && c.state == StateHalfOpen) || (c.state == StateClosed && c.failures >= c.opts.failureThreshold) {
+		c.openedAt = time.Now()
+		c.transit(StateOpen)
+	}
+}
+
+// 打开状态持续超过 openTimeout 后进入半开状态. 调用方需要持有 c.mu
+func (c *Cache) tryHalfOpen() {
+	if c.state == StateOpen && time.Since(c.openedAt) >= c.opts.openTimeout {
+		c.probes = 0
+		c.transit(StateHalfOpen)
+	}
+}
+
+// 切换熔断器状态. 调用方需要持有 c.mu
+func (c *Cache) transit(state State) {
+	if c.state == state {
+		return
+	}
+	c.opts.logger.Warnf("cache breaker state change, from: %s, to: %s", c.state, state)
+	c.state = state
+	c.failures = 0
+}
+
+// 缓存 miss 以及调用方主动取消不视为下一级缓存故障
+func isFailure(err error) bool {
+	return err != nil && !errors.Is(err, consistent_cache.ErrorCacheMiss) && !errors.Is(err, context.Canceled)
+}
@@ -0,0 +1,66 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiaoxuxiansheng/consistent_cache"
+	"github.com/xiaoxuxiansheng/consistent_cache/lib/log"
+)
+
+// 调用次数可观测、返回错误可控制的缓存模块
+type fakeCache struct {
+	consistent_cache.Cache
+	err   error
+	calls int
+}
+
+func (c *fakeCache) Get(ctx context.Context, key string) (string, error) {
+	c.calls++
+	if c.err != nil {
+		return "", c.err
+	}
+	return "v", nil
+}
+
+// 验证点：1 连续失败达到阈值后打开，期间不再调用下一级缓存 2 超时后进入半开状态，探测失败重新打开，探测成功关闭
+// 3 缓存 miss 不视为故障
+func Test_Cache(t *testing.T) {
+	next := &fakeCache{err: errors.New("i/o timeout")}
+	cache := NewCache(next, WithFailureThreshold(2), WithOpenTimeout(50*time.Millisecond), WithLogger(log.NewNopLogger()))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		_, err := cache.Get(ctx, "key")
+		assert.ErrorIs(t, err, next.err)
+	}
+	assert.Equal(t, StateOpen, cache.State())
+	_, err := cache.Get(ctx, "key")
+	assert.ErrorIs(t, err, consistent_cache.ErrorCacheUnavailable)
+	assert.Equal(t, 2, next.calls)
+
+	// 半开状态下探测失败
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, StateHalfOpen, cache.State())
+	_, err = cache.Get(ctx, "key")
+	assert.ErrorIs(t, err, next.err)
+	assert.Equal(t, StateOpen, cache.State())
+
+	// 半开状态下探测成功
+	time.Sleep(60 * time.Millisecond)
+	next.err = nil
+	v, err := cache.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "v", v)
+	assert.Equal(t, StateClosed, cache.State())
+
+	next.err = consistent_cache.ErrorCacheMiss
+	for i := 0; i < 3; i++ {
+		_, err = cache.Get(ctx, "key")
+		assert.ErrorIs(t, err, consistent_cache.ErrorCacheMiss)
+	}
+	assert.Equal(t, StateClosed, cache.State())
+}
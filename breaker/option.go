@@ -0,0 +1,72 @@
+package breaker
+
+import (
+	"time"
+
+	"github.com/xiaoxuxiansheng/consistent_cache"
+	"github.com/xiaoxuxiansheng/consistent_cache/lib/log"
+)
+
+type Options struct {
+	// 连续失败多少次后熔断器打开
+	failureThreshold int
+	// 熔断器打开后，经过多长时间进入半开状态
+	openTimeout time.Duration
+	// 半开状态下允许同时放行的探测请求数量
+	halfOpenProbes int
+	// 日志打印
+	logger consistent_cache.Logger
+}
+
+type Option func(*Options)
+
+const (
+	// 默认连续失败 5 次后熔断器打开
+	DefaultFailureThreshold = 5
+	// 默认熔断器打开 5 s 后进入半开状态
+	DefaultOpenTimeout = 5 * time.Second
+	// 默认半开状态下只放行 1 个探测请求
+	DefaultHalfOpenProbes = 1
+)
+
+func WithFailureThreshold(threshold int) Option {
+	return func(o *Options) {
+		o.failureThreshold = threshold
+	}
+}
+
+func WithOpenTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.openTimeout = timeout
+	}
+}
+
+func WithHalfOpenProbes(probes int) Option {
+	return func(o *Options) {
+		o.halfOpenProbes = probes
+	}
+}
+
+func WithLogger(logger consistent_cache.Logger) Option {
+	return func(o *Options) {
+		o.logger = logger
+	}
+}
+
+func repair(o *Options) {
+	if o.failureThreshold <= 0 {
+		o.failureThreshold = DefaultFailureThreshold
+	}
+
+	if o.openTimeout <= 0 {
+		o.openTimeout = DefaultOpenTimeout
+	}
+
+	if o.halfOpenProbes <= 0 {
+		o.halfOpenProbes = DefaultHalfOpenProbes
+	}
+
+	if o.logger == nil {
+		o.logger = log.GetLogger()
+	}
+}
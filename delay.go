@@ -0,0 +1,198 @@
+package consistent_cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 延时任务的操作类型
+const (
+	// 撤销写流程 token 对 key 的禁用
+	taskEnable = "enable"
+	// 延时双删策略中的第二次删除缓存
+	taskDel = "del"
+)
+
+// 延时任务. 编码格式：{op}|{token}|{version}|{traceparent}|{key}
+type delayTask struct {
+	op    string
+	token string
+	// 数据的版本号，不带有版本号时为 0. 延时双删的第二次删除需要留下版本号墓碑
+	version int64
+	// 触发任务的写流程 span，以 W3C traceparent 格式编码，不存在时为空. 执行任务时开启的 span 链接到该 span
+	traceparent string
+	key         string
+}
+
+func (t delayTask) String() string {
+	return fmt.Sprintf("%s|%s|%d|%s|%s", t.op, t.token, t.version, t.traceparent, t.key)
+}
+
+func parseDelayTask(task string) (delayTask, error) {
+	parts := strings.SplitN(task, "|", 5)
+	if len(parts) != 5 {
+		return delayTask{}, fmt.Errorf("invalid delay task: %s", task)
+	}
+	version, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return delayTask{}, fmt.Errorf("invalid delay task: %s, err: %w", task, err)
+	}
+	return delayTask{op: parts[0], token: parts[1], version: version, traceparent: parts[3], key: parts[4]}, nil
+}
+
+// 延时任务队列中待执行的任务数量，包括延时 enable 操作和延时双删策略中的第二次删除. 未开启 WithDelayQueue 时返回 0
+func (s *Service) Pending(ctx context.Context) (int64, error) {
+	if s.opts.delayQueue == nil {
+		return 0, nil
+	}
+	return s.opts.delayQueue.Len(ctx)
+}
+
+// 将延时操作持久化到延时任务队列中，任务在 delayMilis 之后到期. 未开启 WithDelayQueue 或者入队失败时返回 false，由调用方通过异步协程执行延时操作
+func (s *Service) enqueue(tasks []delayTask, delayMilis int64) bool {
+	if s.opts.delayQueue == nil {
+		return false
+	}
+
+	encoded := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		encoded = append(encoded, task.String())
+	}
+
+	// 写流程的 ctx 可能已经终止，因此使用独立的 ctx
+	tctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.opts.delayQueue.Add(tctx, delayMilis, encoded...); err != nil {
+		s.opts.logger.Errorf("enqueue delay tasks fail, tasks: %v, err: %v", encoded, err)
+		return false
+	}
+	return true
+}
+
+// 撤销写流程 token 对 keys 禁用的延时任务. 任务中记录 ctx 中写流程的 span，相同 ctx 生成的任务编码一致
+func enableTasks(ctx context.Context, keys []string, token string) []delayTask {
+	traceparent := traceparentFrom(ctx)
+	tasks := make([]delayTask, 0, len(keys))
+	for _, key := range keys {
+		tasks = append(tasks, delayTask{op: taskEnable, token: token, traceparent: traceparent, key: key})
+	}
+	return tasks
+}
+
+// 在写操作之前，将撤销禁用的延时任务持久化到延时任务队列中，保证进程在写操作期间崩溃时禁用仍会被撤销
+// 任务的到期时间为写操作的最晚结束时间再加上延时时间：ctx 带有截止时间时取截止时间，且不晚于禁用过期的时间
+// 写操作结束后，由 enable/menable 将任务的到期时间调整为延时结束的时刻
+func (s *Service) prepareEnable(ctx context.Context, keys []string, token string) {
+	if s.opts.delayQueue == nil {
+		return
+	}
+	writeMilis := s.opts.disableExpireSeconds * 1000
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline).Milliseconds() < writeMilis {
+		writeMilis = time.Until(deadline).Milliseconds()
+	}
+	if writeMilis < 0 {
+		writeMilis = 0
+	}
+	// 入队失败时，写操作结束后仍会再次尝试入队，或者通过异步协程撤销禁用
+	s.enqueue(enableTasks(ctx, keys, token), writeMilis+s.opts.enableDelayMilis)
+}
+
+// 持续轮询延时任务队列，领取并执行到期的任务
+func (s *Service) runDelayQueue(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(DefaultDelayQueuePollMilis * time.Millisecond)
+	defer ticker.Stop()
+
+	// 任务在当前 worker 上的连续失败次数，用于计算重试的退避时间
+	attempts := make(map[string]int)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.consumeDelayQueue(ctx, attempts)
+	}
+}
+
+// 领取一批到期的任务并执行. 执行成功的任务从队列中删除，执行失败的任务退避后重新到期
+func (s *Service) consumeDelayQueue(ctx context.Context, attempts map[string]int) {
+	// 失败的任务可能转由其他实例执行成功，其失败次数会残留在当前 worker 上，因此超过上限时清空
+	if len(attempts) > DefaultDelayQueueClaimLimit*100 {
+		for task := range attempts {
+			delete(attempts, task)
+		}
+	}
+
+	queue := s.opts.delayQueue
+	tasks, err := queue.Claim(ctx, DefaultDelayQueueClaimLimit, DefaultDelayQueueClaimMilis)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.opts.logger.Errorf("claim delay tasks fail, err: %v", err)
+		}
+		return
+	}
+
+	for _, task := range tasks {
+		if err := s.execute(ctx, task); err != nil {
+			attempts[task]++
+			backoff := retryBackoffMilis(attempts[task])
+			s.opts.logger.Errorf("execute delay task fail, task: %s, attempts: %d, retry after: %dms, err: %v", task, attempts[task], backoff, err)
+			// 重新入队失败时，任务仍会在领取时长之后重新到期
+			if err := queue.Add(ctx, backoff, task); err != nil {
+				s.opts.logger.Errorf("retry delay task fail, task: %s, err: %v", task, err)
+			}
+			continue
+		}
+
+		delete(attempts, task)
+		// 删除失败时，任务会在领取时长之后被重复执行. 延时任务均为幂等操作，重复执行不影响正确性
+		if err := queue.Remove(ctx, task); err != nil {
+			s.opts.logger.Errorf("remove delay task fail, task: %s, err: %v", task, err)
+		}
+	}
+}
+
+// 执行一个延时任务
+func (s *Service) execute(ctx context.Context, task string) error {
+	t, err := parseDelayTask(task)
+	if err != nil {
+		// 无法解析的任务重试也无济于事，直接丢弃
+		s.opts.logger.Warnf("drop delay task, err: %v", err)
+		return nil
+	}
+
+	switch t.op {
+	case taskEnable:
+		// 任务到期时延时已经结束，立即撤销禁用. span 链接到触发任务的写流程 span
+		_, span := s.startLinkedSpan(contextWithTraceparent(t.traceparent), "consistent_cache.Enable", t.key)
+		start := time.Now()
+		err := s.cache.Enable(trace.ContextWithSpan(ctx, span), t.key, t.token, 0)
+		s.opts.metrics.ObserveEnable(time.Since(start), err)
+		endSpan(span, err)
+		return err
+	case taskDel:
+		return s.cache.Del(ctx, t.key, s.versionTombstoneOptions(t.version)...)
+	default:
+		s.opts.logger.Warnf("drop delay task with unknown op: %s", task)
+		return nil
+	}
+}
+
+// 第 attempts 次失败后的重试退避时间，单位：毫秒
+func retryBackoffMilis(attempts int) int64 {
+	backoff := int64(DefaultDelayQueueRetryMilis)
+	for i := 1; i < attempts && backoff < DefaultDelayQueueMaxRetryMilis; i++ {
+		backoff *= 2
+	}
+	if backoff > DefaultDelayQueueMaxRetryMilis {
+		backoff = DefaultDelayQueueMaxRetryMilis
+	}
+	return backoff
+}
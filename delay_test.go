@@ -0,0 +1,143 @@
+package consistent_cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseDelayTask(t *testing.T) {
+	task := delayTask{op: taskEnable, token: "host_1_2_3", traceparent: "00-0102030405060708090a0b0c0d0e0f10-0102030405060708-01", key: "a|b"}
+	got, err := parseDelayTask(task.String())
+	assert.NoError(t, err)
+	assert.Equal(t, task, got)
+
+	task = delayTask{op: taskDel, token: "host_1_2_3", version: 3, key: "key"}
+	got, err = parseDelayTask(task.String())
+	assert.NoError(t, err)
+	assert.Equal(t, task, got)
+
+	_, err = parseDelayTask("enable|token||key")
+	assert.Error(t, err)
+	_, err = parseDelayTask("del|token|v1||key")
+	assert.Error(t, err)
+}
+
+func Test_retryBackoffMilis(t *testing.T) {
+	assert.Equal(t, int64(100), retryBackoffMilis(1))
+	assert.Equal(t, int64(200), retryBackoffMilis(2))
+	assert.Equal(t, int64(400), retryBackoffMilis(3))
+	assert.Equal(t, int64(DefaultDelayQueueMaxRetryMilis), retryBackoffMilis(100))
+}
+
+// 内存实现的延时任务队列，记录每个任务的到期时间
+type memoryDelayQueue struct {
+	mu   sync.Mutex
+	dues map[string]time.Time
+}
+
+func (q *memoryDelayQueue) Add(ctx context.Context, delayMilis int64, tasks ...string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, task := range tasks {
+		q.dues[task] = time.Now().Add(time.Duration(delayMilis) * time.Millisecond)
+	}
+	return nil
+}
+
+func (q *memoryDelayQueue) Claim(ctx context.Context, limit int, claimMilis int64) ([]string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var tasks []string
+	for task, due := range q.dues {
+		if len(tasks) < limit && !due.After(time.Now()) {
+			tasks = append(tasks, task)
+			q.dues[task] = time.Now().Add(time.Duration(claimMilis) * time.Millisecond)
+		}
+	}
+	return tasks, nil
+}
+
+func (q *memoryDelayQueue) Remove(ctx context.Context, tasks ...string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, task := range tasks {
+		delete(q.dues, task)
+	}
+	return nil
+}
+
+func (q *memoryDelayQueue) Len(ctx context.Context) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return int64(len(q.dues)), nil
+}
+
+// 前 failures 次 enable 操作失败的缓存模块
+type flakyEnableCache struct {
+	Cache
+	mu       sync.Mutex
+	failures int
+	enables  int
+}
+
+func (c *flakyEnableCache) Disable(ctx context.Context, key, token string, expireSeconds int64) error {
+	return nil
+}
+
+func (c *flakyEnableCache) Del(ctx context.Context, key string, opts ...CacheOption) error {
+	return nil
+}
+
+func (c *flakyEnableCache) Enable(ctx context.Context, key, token string, delayMilis int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.enables++; c.enables <= c.failures {
+		return errors.New("i/o timeout")
+	}
+	return nil
+}
+
+func (c *flakyEnableCache) enableCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enables
+}
+
+// 写 db 时记录延时任务队列中待执行任务数量的数据库模块
+type pendingRecordDB struct {
+	DB
+	service *Service
+	pending int64
+}
+
+func (d *pendingRecordDB) Put(ctx context.Context, obj Object) error {
+	var err error
+	d.pending, err = d.service.Pending(ctx)
+	return err
+}
+
+// 验证点：1 写 db 之前 enable 任务已经持久化 2 worker 领取到期任务，执行失败后退避重试，直到执行成功并从队列中删除
+func Test_DelayQueue(t *testing.T) {
+	queue := &memoryDelayQueue{dues: make(map[string]time.Time)}
+	cache := &flakyEnableCache{failures: 2}
+	db := &pendingRecordDB{}
+	service := newTestService(cache, db, WithDelayQueue(queue), WithEnableDelayMilis(50))
+	defer service.Close()
+	db.service = service
+	ctx := context.Background()
+
+	assert.NoError(t, service.Put(ctx, &strategyObject{key: "key"}))
+	assert.Equal(t, int64(1), db.pending)
+
+	// 失败两次后执行成功，任务从队列中删除
+	assert.Eventually(t, func() bool {
+		pending, err := service.Pending(ctx)
+		return err == nil && pending == 0
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 3, cache.enableCount())
+}
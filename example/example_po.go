@@ -6,7 +6,7 @@ import (
 
 type Example struct {
 	ID   uint   `json:"id" gorm:"primarykey"`
-	Key_ string `json:"key" gorm:"column:key"`
+	Key_ string `json:"key" gorm:"column:key" cc:"key"`
 	Data string `json:"data" gorm:"column:data"`
 }
 
@@ -22,6 +22,9 @@ const (
 	mysqlDSN = "请输入 mysql dsn"
 )
 
+// 构造缓存一致性服务实例时使用的一致性策略，由 Test_Consistent_Cache_Strategies 切换
+var strategyType = consistent_cache.StrategyDisableEnable
+
 func newService() *consistent_cache.Service {
 	// 缓存模块
 	cache := redis.NewRedisCache(&redis.Config{
@@ -33,6 +36,8 @@ func newService() *consistent_cache.Service {
 	return consistent_cache.NewService(cache, db,
 		consistent_cache.WithCacheExpireSeconds(120),
 		consistent_cache.WithDisableExpireSeconds(1),
+		consistent_cache.WithStrategy(strategyType),
+		consistent_cache.WithDoubleDeleteDelayMilis(500),
 	)
 }
 
@@ -274,3 +279,236 @@ func Test_Consistent_Cache_Read_Write(t *testing.T) {
 	// 读取结果应该等同于最晚一笔写入的内容
 	assert.Equal(t, datas[len(datas)-1].Data, data.Data)
 }
+
+// 删除操作 验证点：删除后读取不到数据，且缓存中写入的是 NullData
+func Test_Consistent_Cache_Del(t *testing.T) {
+	// 构造缓存一致性服务实例
+	service := newService()
+	ctx := context.Background()
+
+	key := time.Now().String()
+	data := Example{
+		Key_: key,
+		Data: key,
+	}
+	// 写操作
+	if err := service.Put(ctx, &data); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 删除操作
+	if err := service.Del(ctx, &data); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 缓冲一秒，等待删除操作的 disable 操作过期
+	<-time.After(time.Second)
+
+	// 第一次读取，缓存 miss，db 中也不存在数据
+	receiver := Example{
+		Key_: key,
+	}
+	useCache, err := service.Get(ctx, &receiver)
+	assert.Equal(t, false, useCache)
+	assert.ErrorIs(t, err, consistent_cache.ErrorDataNotExist)
+
+	// 第二次读取，命中缓存中的 NullData
+	useCache, err = service.Get(ctx, &receiver)
+	assert.Equal(t, true, useCache)
+	assert.ErrorIs(t, err, consistent_cache.ErrorDataNotExist)
+}
+
+// 批量读操作 验证点：1 数据正确性 2 第二次批量读全部命中缓存
+func Test_Consistent_Cache_MGet(t *testing.T) {
+	// 构造缓存一致性服务实例
+	service := newService()
+	ctx := context.Background()
+
+	// 数据统一前缀
+	prefix := time.Now().String() + "-"
+	// 写入 0~4 共 5 笔数据，5~9 不写入
+	for i := 0; i < 5; i++ {
+		data := Example{
+			Key_: prefix + cast.ToString(i),
+			Data: prefix + cast.ToString(i),
+		}
+		if err := service.Put(ctx, &data); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// 缓冲一秒，等待写操作的 disable 操作过期
+	<-time.After(time.Second)
+
+	newObjs := func() []consistent_cache.Object {
+		objs := make([]consistent_cache.Object, 0, 10)
+		for i := 0; i < 10; i++ {
+			objs = append(objs, &Example{Key_: prefix + cast.ToString(i)})
+		}
+		return objs
+	}
+
+	// 第一次批量读，全部缓存 miss
+	objs := newObjs()
+	statuses, err := service.MGet(ctx, objs)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i, obj := range objs {
+		if i < 5 {
+			assert.Equal(t, consistent_cache.GetStatusMiss, statuses[i])
+			assert.Equal(t, prefix+cast.ToString(i), obj.(*Example).Data)
+			continue
+		}
+		assert.Equal(t, consistent_cache.GetStatusNotExist, statuses[i])
+	}
+
+	// 第二次批量读，全部命中缓存
+	objs = newObjs()
+	if statuses, err = service.MGet(ctx, objs); err != nil {
+		t.Error(err)
+		return
+	}
+	for i, obj := range objs {
+		if i < 5 {
+			assert.Equal(t, consistent_cache.GetStatusHit, statuses[i])
+			assert.Equal(t, prefix+cast.ToString(i), obj.(*Example).Data)
+			continue
+		}
+		assert.Equal(t, consistent_cache.GetStatusNotExist, statuses[i])
+	}
+}
+
+// 批量写操作 验证点：批量写入后能够读取到正确结果
+func Test_Consistent_Cache_MPut(t *testing.T) {
+	// 构造缓存一致性服务实例
+	service := newService()
+	ctx := context.Background()
+
+	// 数据统一前缀
+	prefix := time.Now().String() + "-"
+	objs := make([]consistent_cache.Object, 0, 10)
+	for i := 0; i < 10; i++ {
+		objs = append(objs, &Example{
+			Key_: prefix + cast.ToString(i),
+			Data: prefix + cast.ToString(i),
+		})
+	}
+
+	// 批量写操作
+	for _, err := range service.MPut(ctx, objs) {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// 读操作
+	for i := 0; i < 10; i++ {
+		data := Example{
+			Key_: prefix + cast.ToString(i),
+		}
+		if _, err := service.Get(ctx, &data); err != nil {
+			t.Error(err)
+			continue
+		}
+		assert.Equal(t, prefix+cast.ToString(i), data.Data)
+	}
+}
+
+// 自定义数据源 验证点：1 缓存 miss 时通过 loader 加载数据 2 Invalidate 后重新通过 loader 加载数据
+func Test_Consistent_Cache_GetOrLoad(t *testing.T) {
+	// 构造缓存一致性服务实例
+	service := newService()
+	ctx := context.Background()
+
+	key := time.Now().String()
+	// 模拟的外部数据源
+	source := key + "-1"
+	var loadCnt int
+	newLoader := func(data *Example) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			loadCnt++
+			data.Data = source
+			return nil
+		}
+	}
+
+	// 第一次读取，缓存 miss，通过 loader 加载数据
+	data := Example{Key_: key}
+	useCache, err := service.GetOrLoad(ctx, &data, newLoader(&data))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Equal(t, false, useCache)
+	assert.Equal(t, source, data.Data)
+
+	// 第二次读取，命中缓存
+	data = Example{Key_: key}
+	if useCache, err = service.GetOrLoad(ctx, &data, newLoader(&data)); err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Equal(t, true, useCache)
+	assert.Equal(t, source, data.Data)
+	assert.Equal(t, 1, loadCnt)
+
+	// 数据源变更后，使缓存失效
+	source = key + "-2"
+	if err = service.Invalidate(ctx, key); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 缓冲一秒，等待 disable 操作过期
+	<-time.After(time.Second)
+
+	// 再次读取，通过 loader 加载到新数据
+	data = Example{Key_: key}
+	if useCache, err = service.GetOrLoad(ctx, &data, newLoader(&data)); err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Equal(t, false, useCache)
+	assert.Equal(t, source, data.Data)
+	assert.Equal(t, 2, loadCnt)
+}
+
+// 泛型版本 验证点：通过 TypedService 读写数据，无需手动构造 Object
+func Test_Consistent_Cache_Typed(t *testing.T) {
+	// 构造泛型版本的一致性缓存服务实例，key 通过 Example 中的 `cc:"key"` tag 获取
+	service := consistent_cache.NewTypedService[Example](newService())
+	ctx := context.Background()
+
+	key := time.Now().String()
+	// 写操作
+	if err := service.Put(ctx, Example{Key_: key, Data: key}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 缓冲一秒，等待写操作的 disable 操作过期
+	<-time.After(time.Second)
+
+	// 第一次读操作，缓存 miss
+	data, info, err := service.Get(ctx, key)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Equal(t, false, info.UseCache)
+	assert.Equal(t, key, data.Data)
+
+	// 第二次读操作，命中缓存
+	if data, info, err = service.Get(ctx, key); err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Equal(t, true, info.UseCache)
+	assert.Equal(t, key, data.Data)
+}
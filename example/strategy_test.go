@@ -0,0 +1,42 @@
+package example
+
+import (
+	"testing"
+
+	"github.com/xiaoxuxiansheng/consistent_cache"
+)
+
+// 待验证的一致性策略
+var strategies = []struct {
+	name         string
+	strategyType consistent_cache.StrategyType
+}{
+	{name: "disable_enable", strategyType: consistent_cache.StrategyDisableEnable},
+	{name: "double_delete", strategyType: consistent_cache.StrategyDoubleDelete},
+}
+
+// 分别在每种一致性策略下执行验证. 执行期间 newService 使用对应的一致性策略
+func forEachStrategy(t *testing.T, f func(t *testing.T)) {
+	for _, strategy := range strategies {
+		strategy := strategy
+		t.Run(strategy.name, func(t *testing.T) {
+			prev := strategyType
+			strategyType = strategy.strategyType
+			defer func() { strategyType = prev }()
+			f(t)
+		})
+	}
+}
+
+// 验证点：已有的读写用例在每种一致性策略下均能通过
+func Test_Consistent_Cache_Strategies(t *testing.T) {
+	forEachStrategy(t, func(t *testing.T) {
+		t.Run("correct", Test_Consistent_Cache_Correct)
+		t.Run("read_write", Test_Consistent_Cache_Read_Write)
+		t.Run("del", Test_Consistent_Cache_Del)
+		t.Run("mget", Test_Consistent_Cache_MGet)
+		t.Run("mput", Test_Consistent_Cache_MPut)
+		t.Run("get_or_load", Test_Consistent_Cache_GetOrLoad)
+		t.Run("typed", Test_Consistent_Cache_Typed)
+	})
+}
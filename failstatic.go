@@ -0,0 +1,30 @@
+package consistent_cache
+
+import (
+	"context"
+	"errors"
+)
+
+// 开启 fail-static 模式时，写缓存需要额外写入影子副本
+func (s *Service) shadowOptions() []CacheOption {
+	if s.opts.shadowExpireSeconds <= 0 {
+		return nil
+	}
+	return []CacheOption{WithShadowExpireSeconds(s.opts.shadowExpireSeconds)}
+}
+
+// 加载数据失败时降级读取影子副本. 影子副本不存在或读取失败时，返回加载数据的原始错误
+func (s *Service) readShadow(ctx context.Context, obj Object, loadErr error) (GetInfo, error) {
+	v, err := s.cache.GetShadow(ctx, obj.Key())
+	if err != nil {
+		if !errors.Is(err, ErrorCacheMiss) {
+			s.opts.logger.Errorf("get shadow fail, key: %s, err: %v", obj.Key(), err)
+		}
+		return GetInfo{}, loadErr
+	}
+
+	s.opts.logger.Warnf("load data fail, serve shadow, key: %s, err: %v", obj.Key(), loadErr)
+	ReportCacheSource(ctx, SourceCache)
+	v, _ = s.openEnvelope(v)
+	return GetInfo{UseCache: true, Degraded: true}, readCache(obj, v)
+}
@@ -0,0 +1,61 @@
+package consistent_cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 只存在影子副本的缓存模块
+type shadowCache struct {
+	Cache
+	shadows map[string]string
+}
+
+func (c *shadowCache) Get(ctx context.Context, key string) (string, error) {
+	return "", ErrorCacheMiss
+}
+
+func (c *shadowCache) GetWithTTL(ctx context.Context, key string) (string, int64, error) {
+	v, err := c.Get(ctx, key)
+	return v, -1, err
+}
+
+func (c *shadowCache) GetShadow(ctx context.Context, key string) (string, error) {
+	v, ok := c.shadows[key]
+	if !ok {
+		return "", ErrorCacheMiss
+	}
+	return v, nil
+}
+
+// 不可用的数据库模块
+type unavailableDB struct {
+	DB
+}
+
+func (unavailableDB) Get(ctx context.Context, obj Object) error {
+	return errDBUnavailable
+}
+
+var errDBUnavailable = errors.New("db unavailable")
+
+// 验证点：1 加载数据失败时降级返回影子副本，并上报为降级读 2 影子副本不存在时返回加载数据的原始错误
+func Test_FailStatic(t *testing.T) {
+	cache := &shadowCache{shadows: map[string]string{"key": `{"k":"key","count":"1"}`}}
+	metrics := &recordMetrics{}
+	service := newTestService(cache, unavailableDB{}, WithFailStatic(600), WithMetrics(metrics))
+	ctx := context.Background()
+
+	obj := &counterObject{K: "key"}
+	info, err := service.GetWithInfo(ctx, obj)
+	assert.NoError(t, err)
+	assert.Equal(t, GetInfo{UseCache: true, Degraded: true}, info)
+	assert.Equal(t, "1", obj.Count)
+
+	_, err = service.GetWithInfo(ctx, &counterObject{K: "other"})
+	assert.ErrorIs(t, err, errDBUnavailable)
+	assert.Equal(t, []string{GetResultDegraded}, metrics.gets)
+}
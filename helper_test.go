@@ -0,0 +1,145 @@
+package consistent_cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/xiaoxuxiansheng/consistent_cache/lib/log"
+)
+
+// 构造测试使用的 Service. 日志不输出，避免在工作目录下生成日志文件
+func newTestService(cache Cache, db DB, opts ...Option) *Service {
+	return NewService(cache, db, append([]Option{WithLogger(log.NewNopLogger())}, opts...)...)
+}
+
+// 测试使用的数据对象
+type counterObject struct {
+	K     string `json:"k"`
+	Count string `json:"count"`
+}
+
+func (o *counterObject) KeyColumn() string { return "k" }
+func (o *counterObject) Key() string       { return o.K }
+func (o *counterObject) Write() (string, error) {
+	body, err := json.Marshal(o)
+	return string(body), err
+}
+func (o *counterObject) Read(body string) error { return json.Unmarshal([]byte(body), o) }
+
+// 内存实现的缓存模块，可以控制读流程写缓存机制是否启用. 写流程的禁用与启用为空操作，删除时记录配置项
+type memoryCache struct {
+	Cache
+	mu      sync.Mutex
+	values  map[string]string
+	enabled bool
+	delOpts []*CacheOptions
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{values: make(map[string]string), enabled: true}
+}
+
+func (c *memoryCache) Get(ctx context.Context, key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.values[key]
+	if !ok {
+		return "", ErrorCacheMiss
+	}
+	return v, nil
+}
+
+func (c *memoryCache) GetWithTTL(ctx context.Context, key string) (string, int64, error) {
+	v, err := c.Get(ctx, key)
+	return v, -1, err
+}
+
+func (c *memoryCache) IsEnabled(ctx context.Context, key string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enabled, nil
+}
+
+func (c *memoryCache) PutWhenEnable(ctx context.Context, key, value string, expireSeconds int64, opts ...CacheOption) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.enabled {
+		return false, nil
+	}
+	c.values[key] = value
+	return true, nil
+}
+
+func (c *memoryCache) Disable(ctx context.Context, key, token string, expireSeconds int64) error {
+	return nil
+}
+
+func (c *memoryCache) Enable(ctx context.Context, key, token string, delayMilis int64) error {
+	return nil
+}
+
+func (c *memoryCache) Del(ctx context.Context, key string, opts ...CacheOption) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.values, key)
+	c.delOpts = append(c.delOpts, NewCacheOptions(opts...))
+	return nil
+}
+
+// 已经执行的删除操作的配置项
+func (c *memoryCache) dels() []*CacheOptions {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*CacheOptions(nil), c.delOpts...)
+}
+
+func (c *memoryCache) set(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] = value
+}
+
+func (c *memoryCache) get(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *memoryCache) setEnabled(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.enabled = enabled
+}
+
+// 内存实现的数据库模块，所有 key 读取到的 count 均为 count，count 为空时数据不存在. 写入时更新 count
+type counterDB struct {
+	DB
+	mu    sync.Mutex
+	count string
+}
+
+func (d *counterDB) Get(ctx context.Context, obj Object) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.count == "" {
+		return ErrorDBMiss
+	}
+	return obj.Read(fmt.Sprintf(`{"k":%q,"count":%q}`, obj.Key(), d.count))
+}
+
+func (d *counterDB) Put(ctx context.Context, obj Object) error {
+	body, err := obj.Write()
+	if err != nil {
+		return err
+	}
+	var counter counterObject
+	if err := json.Unmarshal([]byte(body), &counter); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.count = counter.Count
+	return nil
+}
@@ -0,0 +1,186 @@
+package consistent_cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// 读操作的处理函数
+type GetHandler func(ctx context.Context, obj Object) (GetResult, error)
+
+// 读操作拦截器. 可以在调用 next 前后添加逻辑，也可以不调用 next 直接返回
+type GetInterceptor func(ctx context.Context, obj Object, next GetHandler) (GetResult, error)
+
+// 写操作的处理函数
+type PutHandler func(ctx context.Context, obj Object) error
+
+// 写操作拦截器. 可以在调用 next 前后添加逻辑，也可以不调用 next 直接返回
+type PutInterceptor func(ctx context.Context, obj Object, next PutHandler) error
+
+// 写操作的类型. 写操作拦截器通过 WriteKindFromContext 区分 Put、Del 以及 Invalidate，MPut 中的每笔数据视为 Put
+type WriteKind int
+
+const (
+	WriteKindPut WriteKind = iota
+	WriteKindDel
+	// Invalidate 时拦截器获取到的 Object 只提供 Key
+	WriteKindInvalidate
+)
+
+func (k WriteKind) String() string {
+	switch k {
+	case WriteKindDel:
+		return "del"
+	case WriteKindInvalidate:
+		return "invalidate"
+	default:
+		return "put"
+	}
+}
+
+type writeKindKey struct{}
+
+// 将写操作的类型注入 ctx 中
+func withWriteKind(ctx context.Context, kind WriteKind) context.Context {
+	return context.WithValue(ctx, writeKindKey{}, kind)
+}
+
+// 获取 ctx 中写操作的类型，供写操作拦截器使用. 未设置时返回 WriteKindPut
+func WriteKindFromContext(ctx context.Context) WriteKind {
+	kind, _ := ctx.Value(writeKindKey{}).(WriteKind)
+	return kind
+}
+
+// Invalidate 时传递给写操作拦截器的 Object，只提供 key
+type invalidateObject struct {
+	key string
+}
+
+func (o invalidateObject) KeyColumn() string { return "" }
+
+func (o invalidateObject) Key() string { return o.key }
+
+func (o invalidateObject) Write() (string, error) {
+	return "", errors.New("invalidate object can't be written")
+}
+
+func (o invalidateObject) Read(body string) error {
+	return errors.New("invalidate object can't be read")
+}
+
+// 将拦截器串联在 handler 之前. 第一个拦截器位于最外层
+func chainGet(interceptors []GetInterceptor, handler GetHandler) GetHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, obj Object) (GetResult, error) {
+			return interceptor(ctx, obj, next)
+		}
+	}
+	return handler
+}
+
+func chainPut(interceptors []PutInterceptor, handler PutHandler) PutHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, obj Object) error {
+			return interceptor(ctx, obj, next)
+		}
+	}
+	return handler
+}
+
+// 批量操作中的每笔数据分别执行 intercept，即经过各自的拦截器. 调用了 next 的数据合并为一次批量操作 batch，batch 的结果与 idxs 一一对应
+// 每笔数据的拦截器在独立的协程中执行，batch 在全部数据调用 next 或者直接返回之后执行. 返回每笔数据经过拦截器后的结果
+func interceptBatch[R any](n int, intercept func(i int, next func() (R, error)) (R, error),
+	batch func(idxs []int) ([]R, []error)) ([]R, []error) {
+	var (
+		// 调用了 next 的数据下标，未调用 next 直接返回的数据为 -1
+		settled  = make(chan int, n)
+		done     = make(chan struct{})
+		nexts    = make([]R, n)
+		nextErrs = make([]error, n)
+		res      = make([]R, n)
+		errs     = make([]error, n)
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var once sync.Once
+			res[i], errs[i] = intercept(i, func() (R, error) {
+				once.Do(func() { settled <- i })
+				<-done
+				return nexts[i], nextErrs[i]
+			})
+			once.Do(func() { settled <- -1 })
+		}(i)
+	}
+
+	// 1 等待全部数据调用 next 或者直接返回
+	idxs := make([]int, 0, n)
+	for k := 0; k < n; k++ {
+		if i := <-settled; i >= 0 {
+			idxs = append(idxs, i)
+		}
+	}
+
+	// 2 调用了 next 的数据合并为一次批量操作，结果交还给各自的拦截器
+	if len(idxs) > 0 {
+		rs, es := batch(idxs)
+		for k, i := range idxs {
+			nexts[i], nextErrs[i] = rs[k], es[k]
+		}
+	}
+	close(done)
+	wg.Wait()
+	return res, errs
+}
+
+// 打印读操作日志的拦截器. 数据不存在不视为错误
+func GetLoggingInterceptor(logger Logger) GetInterceptor {
+	return func(ctx context.Context, obj Object, next GetHandler) (GetResult, error) {
+		res, err := next(ctx, obj)
+		if err != nil && !errors.Is(err, ErrorDataNotExist) {
+			logger.Errorf("get fail, key: %s, err: %v", obj.Key(), err)
+		} else {
+			logger.Infof("get resp, key: %s, source: %s, fill: %s, err: %v", obj.Key(), res.Source, res.Fill, err)
+		}
+		return res, err
+	}
+}
+
+// 打印写操作日志的拦截器
+func PutLoggingInterceptor(logger Logger) PutInterceptor {
+	return func(ctx context.Context, obj Object, next PutHandler) error {
+		err := next(ctx, obj)
+		if err != nil {
+			logger.Errorf("%s fail, key: %s, err: %v", WriteKindFromContext(ctx), obj.Key(), err)
+		} else {
+			logger.Infof("%s resp, key: %s", WriteKindFromContext(ctx), obj.Key())
+		}
+		return err
+	}
+}
+
+// 统计读操作耗时的拦截器，每次读操作结束后通过 observe 上报
+func GetTimingInterceptor(observe func(key string, cost time.Duration, err error)) GetInterceptor {
+	return func(ctx context.Context, obj Object, next GetHandler) (GetResult, error) {
+		start := time.Now()
+		res, err := next(ctx, obj)
+		observe(obj.Key(), time.Since(start), err)
+		return res, err
+	}
+}
+
+// 统计写操作耗时的拦截器，每次写操作结束后通过 observe 上报
+func PutTimingInterceptor(observe func(key string, cost time.Duration, err error)) PutInterceptor {
+	return func(ctx context.Context, obj Object, next PutHandler) error {
+		start := time.Now()
+		err := next(ctx, obj)
+		observe(obj.Key(), time.Since(start), err)
+		return err
+	}
+}
@@ -0,0 +1,319 @@
+package consistent_cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 记录日志内容的日志模块
+type recordLogger struct {
+	mu     sync.Mutex
+	errors []string
+	infos  []string
+}
+
+func (l *recordLogger) Errorf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors = append(l.errors, fmt.Sprintf(format, v...))
+}
+
+func (l *recordLogger) Warnf(format string, v ...interface{}) {}
+
+func (l *recordLogger) Infof(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.infos = append(l.infos, fmt.Sprintf(format, v...))
+}
+
+func (l *recordLogger) Debugf(format string, v ...interface{}) {}
+
+// 记录写入数据的数据库模块，err 不为空时写入失败
+type putRecordDB struct {
+	DB
+	err  error
+	puts []string
+}
+
+func (d *putRecordDB) Put(ctx context.Context, obj Object) error {
+	if d.err != nil {
+		return d.err
+	}
+	d.puts = append(d.puts, obj.Key())
+	return nil
+}
+
+// 验证点：1 拦截器按添加顺序由外到内执行 2 读操作拦截器可以不调用 next 直接返回，此时不读缓存也不读 db
+func Test_GetInterceptors(t *testing.T) {
+	var trace []string
+	record := func(name string) GetInterceptor {
+		return func(ctx context.Context, obj Object, next GetHandler) (GetResult, error) {
+			trace = append(trace, name+" before")
+			res, err := next(ctx, obj)
+			trace = append(trace, name+" after")
+			return res, err
+		}
+	}
+	// key 为 local 的数据直接由拦截器返回
+	local := func(ctx context.Context, obj Object, next GetHandler) (GetResult, error) {
+		if obj.Key() != "local" {
+			return next(ctx, obj)
+		}
+		obj.(*counterObject).Count = "local"
+		return GetResult{GetInfo: GetInfo{UseCache: true}, Source: SourceCache}, nil
+	}
+
+	service := newTestService(newMemoryCache(), &counterDB{count: "1"},
+		WithGetInterceptors(record("outer"), local), WithGetInterceptors(record("inner")))
+	ctx := context.Background()
+
+	obj := &counterObject{K: "key"}
+	_, err := service.Get(ctx, obj)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", obj.Count)
+	assert.Equal(t, []string{"outer before", "inner before", "inner after", "outer after"}, trace)
+
+	// 短路返回时，内层拦截器不执行，db 不可用也不影响读操作
+	trace = nil
+	service = newTestService(newMemoryCache(), unavailableDB{},
+		WithGetInterceptors(record("outer"), local), WithGetInterceptors(record("inner")))
+	obj = &counterObject{K: "local"}
+	res, err := service.GetWithResult(ctx, obj)
+	assert.NoError(t, err)
+	assert.Equal(t, "local", obj.Count)
+	assert.Equal(t, SourceCache, res.Source)
+	assert.Equal(t, []string{"outer before", "outer after"}, trace)
+}
+
+// 验证点：1 写操作拦截器包裹写流程，可以在 next 前后添加逻辑并获取写流程的错误 2 写操作拦截器可以不调用 next 直接返回
+func Test_PutInterceptors(t *testing.T) {
+	var trace []string
+	wrap := func(ctx context.Context, obj Object, next PutHandler) error {
+		trace = append(trace, "before")
+		err := next(ctx, obj)
+		trace = append(trace, fmt.Sprintf("after: %v", err))
+		return err
+	}
+	errDenied := errors.New("permission denied")
+	deny := func(ctx context.Context, obj Object, next PutHandler) error {
+		if obj.Key() == "denied" {
+			return errDenied
+		}
+		return next(ctx, obj)
+	}
+
+	db := &putRecordDB{}
+	service := newTestService(&flakyEnableCache{}, db, WithPutInterceptors(wrap, deny))
+	ctx := context.Background()
+
+	assert.NoError(t, service.Put(ctx, &strategyObject{key: "key"}))
+	assert.Equal(t, []string{"key"}, db.puts)
+	assert.Equal(t, []string{"before", "after: <nil>"}, trace)
+
+	// 被拒绝的写操作不写 db，外层拦截器获取到拒绝的错误
+	trace = nil
+	assert.ErrorIs(t, service.Put(ctx, &strategyObject{key: "denied"}), errDenied)
+	assert.Equal(t, []string{"key"}, db.puts)
+	assert.Equal(t, []string{"before", "after: permission denied"}, trace)
+}
+
+// 验证点：1 Del、Invalidate 经过写操作拦截器，拦截器通过 WriteKindFromContext 区分写操作的类型 2 拦截器拒绝的删除操作不删除 db 中的数据
+func Test_PutInterceptors_DelAndInvalidate(t *testing.T) {
+	var trace []string
+	record := func(ctx context.Context, obj Object, next PutHandler) error {
+		trace = append(trace, fmt.Sprintf("%s %s", WriteKindFromContext(ctx), obj.Key()))
+		return next(ctx, obj)
+	}
+	errDenied := errors.New("permission denied")
+	deny := func(ctx context.Context, obj Object, next PutHandler) error {
+		if WriteKindFromContext(ctx) == WriteKindDel && obj.Key() == "denied" {
+			return errDenied
+		}
+		return next(ctx, obj)
+	}
+
+	db := &opRecordDB{}
+	service := newTestService(newWriteBehindCache(), db, WithPutInterceptors(record, deny))
+	ctx := context.Background()
+
+	assert.NoError(t, service.Del(ctx, &counterObject{K: "a"}))
+	assert.ErrorIs(t, service.Del(ctx, &counterObject{K: "denied"}), errDenied)
+	assert.NoError(t, service.Invalidate(ctx, "b"))
+	assert.Equal(t, []string{"del a", "del denied", "invalidate b"}, trace)
+	assert.Equal(t, []string{"del a"}, db.records())
+}
+
+// 记录批量读操作的缓存模块
+type mgetRecordCache struct {
+	Cache
+	mu     sync.Mutex
+	values map[string]string
+	mgets  [][]string
+}
+
+func (c *mgetRecordCache) MGet(ctx context.Context, keys []string) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mgets = append(c.mgets, keys)
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		if v, ok := c.values[key]; ok {
+			values[key] = v
+		}
+	}
+	return values, nil
+}
+
+func (c *mgetRecordCache) MPutWhenEnable(ctx context.Context, entries []CacheEntry) ([]bool, error) {
+	return make([]bool, len(entries)), nil
+}
+
+// 记录批量读操作的数据库模块
+type mgetRecordDB struct {
+	DB
+	mu     sync.Mutex
+	counts map[string]string
+	mgets  [][]string
+}
+
+func (d *mgetRecordDB) MGet(ctx context.Context, objs []Object) ([]bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	keys := make([]string, 0, len(objs))
+	exists := make([]bool, len(objs))
+	for i, obj := range objs {
+		keys = append(keys, obj.Key())
+		if count, ok := d.counts[obj.Key()]; ok {
+			obj.(*counterObject).Count = count
+			exists[i] = true
+		}
+	}
+	d.mgets = append(d.mgets, keys)
+	return exists, nil
+}
+
+// 验证点：1 MGet、MPut 中的每笔数据分别经过拦截器，调用了 next 的数据合并为一次批量操作 2 拦截器可以对单笔数据直接返回
+func Test_BatchInterceptors(t *testing.T) {
+	var mu sync.Mutex
+	sources := make(map[string]string)
+	record := func(ctx context.Context, obj Object, next GetHandler) (GetResult, error) {
+		res, err := next(ctx, obj)
+		mu.Lock()
+		defer mu.Unlock()
+		sources[obj.Key()] = fmt.Sprintf("%s %v", res.Source, err)
+		return res, err
+	}
+	local := func(ctx context.Context, obj Object, next GetHandler) (GetResult, error) {
+		if obj.Key() != "local" {
+			return next(ctx, obj)
+		}
+		obj.(*counterObject).Count = "local"
+		return GetResult{GetInfo: GetInfo{UseCache: true}, Source: SourceCache}, nil
+	}
+
+	cache := &mgetRecordCache{values: map[string]string{"hit": `{"k":"hit","count":"5"}`}}
+	db := &mgetRecordDB{counts: map[string]string{"miss": "1"}}
+	service := newTestService(cache, db, WithGetInterceptors(record, local))
+	ctx := context.Background()
+
+	objs := []Object{&counterObject{K: "hit"}, &counterObject{K: "miss"}, &counterObject{K: "none"}, &counterObject{K: "local"}}
+	statuses, err := service.MGet(ctx, objs)
+	assert.NoError(t, err)
+	assert.Equal(t, []GetStatus{GetStatusHit, GetStatusMiss, GetStatusNotExist, GetStatusHit}, statuses)
+	assert.Equal(t, "local", objs[3].(*counterObject).Count)
+	assert.Equal(t, map[string]string{
+		"hit":   "cache <nil>",
+		"miss":  "db <nil>",
+		"none":  "none data not exist",
+		"local": "cache <nil>",
+	}, sources)
+	assert.Len(t, cache.mgets, 1)
+	assert.ElementsMatch(t, []string{"hit", "miss", "none"}, cache.mgets[0])
+	assert.Len(t, db.mgets, 1)
+	assert.ElementsMatch(t, []string{"miss", "none"}, db.mgets[0])
+
+	// 被拒绝的数据不写 db，其余数据合并为一次批量写操作
+	errDenied := errors.New("permission denied")
+	deny := func(ctx context.Context, obj Object, next PutHandler) error {
+		if obj.Key() == "denied" {
+			return errDenied
+		}
+		return next(ctx, obj)
+	}
+	opDB := &opRecordDB{}
+	service = newTestService(newWriteBehindCache(), opDB, WithPutInterceptors(deny))
+	errs := service.MPut(ctx, []Object{&counterObject{K: "a", Count: "1"}, &counterObject{K: "denied", Count: "1"}, &counterObject{K: "b", Count: "1"}})
+	assert.Equal(t, []error{nil, errDenied, nil}, errs)
+	assert.ElementsMatch(t, []string{"put a=1", "put b=1"}, opDB.records())
+}
+
+// 验证点：1 读操作成功以及数据不存在时打印 info 日志，失败时打印 error 日志 2 写操作成功时打印 info 日志，失败时打印 error 日志
+func Test_LoggingInterceptors(t *testing.T) {
+	logger := &recordLogger{}
+	service := newTestService(newMemoryCache(), &counterDB{count: "1"}, WithGetInterceptors(GetLoggingInterceptor(logger)))
+	ctx := context.Background()
+
+	_, err := service.Get(ctx, &counterObject{K: "key"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"get resp, key: key, source: db, fill: accepted, err: <nil>"}, logger.infos)
+
+	logger = &recordLogger{}
+	service = newTestService(newMemoryCache(), emptyDB{}, WithGetInterceptors(GetLoggingInterceptor(logger)))
+	_, err = service.Get(ctx, &counterObject{K: "key"})
+	assert.ErrorIs(t, err, ErrorDataNotExist)
+	assert.Len(t, logger.infos, 1)
+	assert.Empty(t, logger.errors)
+
+	logger = &recordLogger{}
+	service = newTestService(newMemoryCache(), unavailableDB{}, WithGetInterceptors(GetLoggingInterceptor(logger)))
+	_, err = service.Get(ctx, &counterObject{K: "key"})
+	assert.ErrorIs(t, err, errDBUnavailable)
+	assert.Equal(t, []string{"get fail, key: key, err: db unavailable"}, logger.errors)
+	assert.Empty(t, logger.infos)
+
+	logger = &recordLogger{}
+	db := &putRecordDB{}
+	service = newTestService(&flakyEnableCache{}, db, WithPutInterceptors(PutLoggingInterceptor(logger)))
+	assert.NoError(t, service.Put(ctx, &strategyObject{key: "key"}))
+	assert.Equal(t, []string{"put resp, key: key"}, logger.infos)
+	db.err = errDBUnavailable
+	assert.ErrorIs(t, service.Put(ctx, &strategyObject{key: "key"}), errDBUnavailable)
+	assert.Equal(t, []string{"put fail, key: key, err: db unavailable"}, logger.errors)
+}
+
+// 验证点：读写操作结束后上报 key、耗时以及错误
+func Test_TimingInterceptors(t *testing.T) {
+	type observed struct {
+		key string
+		err error
+	}
+	var gets, puts []observed
+	getTiming := GetTimingInterceptor(func(key string, cost time.Duration, err error) {
+		assert.Greater(t, cost, time.Duration(0))
+		gets = append(gets, observed{key: key, err: err})
+	})
+	putTiming := PutTimingInterceptor(func(key string, cost time.Duration, err error) {
+		assert.Greater(t, cost, time.Duration(0))
+		puts = append(puts, observed{key: key, err: err})
+	})
+
+	db := &putRecordDB{}
+	service := newTestService(&flakyEnableCache{}, db, WithGetInterceptors(getTiming), WithPutInterceptors(putTiming))
+	ctx := context.Background()
+
+	assert.NoError(t, service.Put(ctx, &strategyObject{key: "a"}))
+	db.err = errDBUnavailable
+	assert.ErrorIs(t, service.Put(ctx, &strategyObject{key: "b"}), errDBUnavailable)
+	assert.Equal(t, []observed{{key: "a"}, {key: "b", err: errDBUnavailable}}, puts)
+
+	service = newTestService(newMemoryCache(), &counterDB{count: "1"}, WithGetInterceptors(getTiming))
+	_, err := service.Get(ctx, &counterObject{K: "key"})
+	assert.NoError(t, err)
+	assert.Equal(t, []observed{{key: "key"}}, gets)
+}
@@ -3,28 +3,69 @@ package consistent_cache
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 var (
 	ErrorDataNotExist = errors.New("data not exist")
 	ErrorCacheMiss    = errors.New("cache miss")
 	ErrorDBMiss       = errors.New("db miss")
+	// 写回模式下，缓冲区已满
+	ErrorWriteBufferFull = errors.New("write buffer full")
+	// 缓存模块不可用，例如熔断器处于打开状态. 读流程遇到该错误时直接读 db 且不写缓存
+	ErrorCacheUnavailable = errors.New("cache unavailable")
 )
 
 const NullData = "Err_Syntax_Null_Data"
 
 // 缓存模块的抽象接口定义
 type Cache interface {
-	// 启用某个 key 对应读流程写缓存机制（默认情况下为启用状态）
-	Enable(ctx context.Context, key string, delayMilis int64) error
-	// 禁用某个 key 对应读流程写缓存机制
-	Disable(ctx context.Context, key string, expireSeconds int64) error
+	// 撤销写流程 token 对 key 的禁用，delayMilis 后生效. 只有全部写流程的禁用都撤销后，key 对应读流程写缓存机制才会启用（默认情况下为启用状态）
+	Enable(ctx context.Context, key, token string, delayMilis int64) error
+	// 以写流程 token 的身份禁用某个 key 对应读流程写缓存机制，并撤销 key 的全部租约. 禁用至多持续 expireSeconds
+	Disable(ctx context.Context, key, token string, expireSeconds int64) error
 	// 读取 key 对应缓存
 	Get(ctx context.Context, key string) (string, error)
-	// 删除 key 对应缓存
-	Del(ctx context.Context, key string) error
+	// 读取 key 对应缓存以及剩余过期时间，单位：毫秒. 剩余过期时间未知时返回 -1
+	GetWithTTL(ctx context.Context, key string) (string, int64, error)
+	// 读取 key 对应的影子副本. 影子副本通过 WithShadowExpireSeconds 写入，删除 key 时一并删除
+	GetShadow(ctx context.Context, key string) (string, error)
+	// 查询某个 key 对应读流程写缓存机制是否启用
+	IsEnabled(ctx context.Context, key string) (bool, error)
+	// 删除 key 对应缓存，并撤销 key 的全部租约. 通过 WithVersion 留下版本号墓碑
+	Del(ctx context.Context, key string, opts ...CacheOption) error
 	// 校验某个 key 对应读流程写缓存机制是否启用，倘若启用则写入缓存（默认情况下为启用状态）
-	PutWhenEnable(ctx context.Context, key, value string, expireSeconds int64) (bool, error)
+	// 通过 WithVersion 开启版本校验，通过 WithLeaseToken 开启租约校验，通过 WithWriterToken 以写流程的身份写缓存
+	PutWhenEnable(ctx context.Context, key, value string, expireSeconds int64, opts ...CacheOption) (bool, error)
+	// 直接写入缓存，不校验读流程写缓存机制是否启用. 通过 WithVersion 开启版本校验
+	Set(ctx context.Context, key, value string, expireSeconds int64, opts ...CacheOption) (bool, error)
+	// 读取 key 对应缓存. 缓存 miss 时返回 ErrorCacheMiss，并尝试以 token 为调用方授予有效期为 leaseMilis 的租约，返回是否获得租约
+	GetWithLease(ctx context.Context, key, token string, leaseMilis int64) (string, bool, error)
+	// 批量读取 keys 对应缓存，返回结果中只包含命中缓存的 key
+	MGet(ctx context.Context, keys []string) (map[string]string, error)
+	// 批量执行 PutWhenEnable，返回结果与 entries 一一对应
+	MPutWhenEnable(ctx context.Context, entries []CacheEntry) ([]bool, error)
+	// 批量禁用 keys 对应读流程写缓存机制，并删除 keys 对应缓存. 通过 WithVersions 留下版本号墓碑
+	MDisableAndDel(ctx context.Context, keys []string, token string, expireSeconds int64, opts ...CacheOption) error
+	// 批量撤销写流程 token 对 keys 的禁用
+	MEnable(ctx context.Context, keys []string, token string, delayMilis int64) error
+	// 尝试获取 key 对应的分布式锁（缓存 miss 锁、写回锁），token 为持有者的唯一标识
+	// 锁已经由 token 持有时，将过期时间续期为 expireMilis 并返回 true
+	Lock(ctx context.Context, key, token string, expireMilis int64) (bool, error)
+	// 释放 key 对应的分布式锁，只有 token 与持有者一致时才会释放
+	Unlock(ctx context.Context, key, token string) error
+}
+
+// 批量写缓存时的一笔数据
+type CacheEntry struct {
+	Key   string
+	Value string
+	// 缓存过期时间，单位：秒
+	ExpireSeconds int64
+	// 数据的版本号. 大于 0 时开启版本校验
+	Version int64
+	// 写入的是否为 NullData. 为 true 时，缓存中存在版本号或版本号墓碑则拒绝写入
+	NullData bool
 }
 
 // 数据库模块的抽象接口定义
@@ -33,6 +74,46 @@ type DB interface {
 	Put(ctx context.Context, obj Object) error
 	// 从数据库读取数据
 	Get(ctx context.Context, obj Object) error
+	// 从数据库删除数据
+	Delete(ctx context.Context, obj Object) error
+	// 从数据库批量读取数据，返回结果与 objs 一一对应，标识每笔数据是否存在
+	MGet(ctx context.Context, objs []Object) ([]bool, error)
+	// 数据批量写入数据库，返回结果与 objs 一一对应，标识每笔数据的写入错误
+	MPut(ctx context.Context, objs []Object) []error
+}
+
+// 延时任务队列的抽象接口定义. 用于持久化写流程中待执行的延时操作，保证进程崩溃或者缓存模块短暂不可用时，延时操作不会丢失
+type DelayQueue interface {
+	// 添加任务，任务在 delayMilis 之后到期. 已存在的任务会更新到期时间
+	Add(ctx context.Context, delayMilis int64, tasks ...string) error
+	// 领取至多 limit 个已到期的任务. 领取后的 claimMilis 内，任务不会被再次领取；倘若期间未被 Remove，则任务重新到期
+	Claim(ctx context.Context, limit int, claimMilis int64) ([]string, error)
+	// 确认任务执行完成，从队列中删除任务
+	Remove(ctx context.Context, tasks ...string) error
+	// 队列中的任务数量
+	Len(ctx context.Context) (int64, error)
+}
+
+// 写回模式下的持久化缓冲区. 写操作先追加到缓冲区中，再由后台协程批量写入 db
+type WriteBuffer interface {
+	// 追加一笔变更，返回单调递增的追加序号. 缓冲区已满时返回 ErrorWriteBufferFull
+	Append(ctx context.Context, key, value string) (int64, error)
+	// 按照追加顺序读取至多 limit 笔尚未确认的变更
+	Read(ctx context.Context, limit int) ([]BufferEntry, error)
+	// 确认变更已经写入 db，从缓冲区中删除
+	Ack(ctx context.Context, ids ...string) error
+	// 缓冲区中尚未确认的变更数量
+	Len(ctx context.Context) (int64, error)
+}
+
+// 写回缓冲区中的一笔变更
+type BufferEntry struct {
+	// 变更在缓冲区中的唯一标识
+	ID string
+	// 追加变更时返回的追加序号
+	Seq   int64
+	Key   string
+	Value string
 }
 
 // 每次读写操作时，操作的一笔数据记录
@@ -48,6 +129,57 @@ type Object interface {
 	Read(body string) error
 }
 
+// 带有版本号的 Object. 版本号需要随每次写操作单调递增，例如取自 updated_at 或者 version 字段
+// 实现了该接口的 Object，读流程写缓存时会拒绝写入低于缓存中已有版本号的数据
+type Versioned interface {
+	Version() int64
+}
+
+// Object 可以选择实现的接口. 复制 object，供后台刷新过期数据时使用
+// 未实现该接口时，对于结构体指针类型的 Object 会进行浅拷贝
+type Cloner interface {
+	Clone() Object
+}
+
+// Object 可以选择实现的接口. 在延时双删策略下，单独指定该类数据第二次删除缓存的延时时间，单位：毫秒
+type DoubleDeleteDelayer interface {
+	DoubleDeleteDelayMilis() int64
+}
+
+// 读操作的结果分类，用于监控指标上报
+const (
+	// 命中缓存
+	GetResultHit = "hit"
+	// 缓存 miss，从 db 中读取到数据
+	GetResultMiss = "miss"
+	// 命中缓存中的 NullData
+	GetResultNullHit = "null_hit"
+	// 缓存 miss，db 中数据不存在
+	GetResultDBMiss = "db_miss"
+	// 加载数据失败，降级返回影子副本
+	GetResultDegraded = "degraded"
+)
+
+// 写流程的步骤，用于监控指标上报
+const (
+	PutStepDisable      = "disable"
+	PutStepDel          = "del"
+	PutStepWrite        = "write"
+	PutStepWriteThrough = "write_through"
+)
+
+// 监控指标上报模块. 实现方需要保证并发安全
+type Metrics interface {
+	// 上报一次读操作的结果，result 取值为 GetResult* 常量
+	ObserveGet(result string)
+	// 上报一次读流程写缓存的结果，accepted 为 false 表示被禁用、租约或版本号校验拒绝
+	ObserveFill(accepted bool)
+	// 上报写流程单个步骤的耗时，step 取值为 PutStep* 常量
+	ObservePutStep(step string, cost time.Duration, err error)
+	// 上报一次延时启用操作的耗时与结果
+	ObserveEnable(cost time.Duration, err error)
+}
+
 // 日志打印输出模块
 type Logger interface {
 	Errorf(format string, v ...interface{})
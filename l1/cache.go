@@ -0,0 +1,448 @@
+package l1
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xiaoxuxiansheng/consistent_cache"
+	"github.com/xiaoxuxiansheng/consistent_cache/lib/runtime"
+)
+
+// 进程间广播失效消息的模块
+type Broker interface {
+	// 广播消息
+	Publish(ctx context.Context, message string) error
+	// 订阅消息. 会阻塞直到 ctx 终止或者连接出错
+	Subscribe(ctx context.Context, handler func(message string)) error
+}
+
+// 失效消息的操作类型
+const (
+	opDisable = "disable"
+	opEnable  = "enable"
+	opDel     = "del"
+)
+
+// 分段版本号的段数
+const epochShards = 256
+
+// 进程内的本地缓存，作为一级缓存包装在其他缓存模块之前
+// 本地缓存只会在读取下一级缓存命中时写入，且与下一级缓存遵循相同的 disable/enable 语义：处于禁用状态的 key 不会写入本地缓存
+type Cache struct {
+	opts *Options
+	// 下一级缓存模块
+	next consistent_cache.Cache
+	lru  *lru
+	// 当前进程的唯一标识，用于忽略自身广播的消息
+	id string
+
+	mu sync.Mutex
+	// 分段版本号. key 每次失效时所在分段的版本号加 1，写本地缓存前需要校验版本号未发生变化
+	// 从而避免在读取下一级缓存期间 key 发生失效，却仍将旧数据写入本地缓存
+	epochs [epochShards]uint64
+	// 处于禁用状态的 key，与下一级缓存一致，按写流程 token 分别记录禁用的截止时间
+	disabled map[string]map[string]time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var _ consistent_cache.Cache = (*Cache)(nil)
+
+// 构造器函数. 倘若设置了 broker，会启动一个常驻协程订阅失效消息，使用完毕后需要调用 Close 方法
+func NewCache(next consistent_cache.Cache, opts ...Option) *Cache {
+	c := Cache{
+		opts:     &Options{},
+		next:     next,
+		id:       runtime.GenerateUniqueID(),
+		disabled: make(map[string]map[string]time.Time),
+		done:     make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(c.opts)
+	}
+
+	repair(c.opts)
+	c.lru = newLRU(c.opts.capacity)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	if c.opts.broker == nil {
+		close(c.done)
+		return &c
+	}
+
+	go c.subscribe(ctx)
+	return &c
+}
+
+// 停止订阅失效消息
+func (c *Cache) Close() {
+	c.cancel()
+	<-c.done
+}
+
+// 撤销写流程 token 对 key 的禁用（默认情况下为启用状态）
+// 只有下一级缓存撤销成功后才撤销本地禁用，避免本地先于下一级缓存恢复写入
+func (c *Cache) Enable(ctx context.Context, key, token string, delayMilis int64) error {
+	if err := c.next.Enable(ctx, key, token, delayMilis); err != nil {
+		return err
+	}
+	c.enable(key, token, delayMilis)
+	c.publish(ctx, opEnable, delayMilis, token, key)
+	return nil
+}
+
+// 以写流程 token 的身份禁用某个 key 对应读流程写缓存机制
+func (c *Cache) Disable(ctx context.Context, key, token string, expireSeconds int64) error {
+	// 先在本地禁用，再禁用下一级缓存，保证下一级缓存中的旧数据不会被写入本地缓存
+	c.disable(key, token, expireSeconds*1000)
+	c.publish(ctx, opDisable, expireSeconds*1000, token, key)
+	return c.next.Disable(ctx, key, token, expireSeconds)
+}
+
+// 读取 key 对应缓存. 读操作指定了 BypassCache 或 ForceRefresh 时跳过本地缓存
+func (c *Cache) Get(ctx context.Context, key string) (string, error) {
+	if skipLocal(ctx) {
+		return c.next.Get(ctx, key)
+	}
+
+	// 1 读取本地缓存
+	if v, ok := c.lru.get(key); ok {
+		consistent_cache.ReportCacheSource(ctx, consistent_cache.SourceL1)
+		return v, nil
+	}
+
+	// 2 读取下一级缓存，命中时写入本地缓存
+	epoch := c.epoch(key)
+	v, err := c.next.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	c.fill(key, v, epoch)
+	return v, nil
+}
+
+// 读取 key 对应缓存以及剩余过期时间. 命中本地缓存时剩余过期时间未知，返回 -1
+func (c *Cache) GetWithTTL(ctx context.Context, key string) (string, int64, error) {
+	if skipLocal(ctx) {
+		return c.next.GetWithTTL(ctx, key)
+	}
+	if v, ok := c.lru.get(key); ok {
+		consistent_cache.ReportCacheSource(ctx, consistent_cache.SourceL1)
+		return v, -1, nil
+	}
+
+	epoch := c.epoch(key)
+	v, ttlMilis, err := c.next.GetWithTTL(ctx, key)
+	if err != nil {
+		return "", 0, err
+	}
+	c.fill(key, v, epoch)
+	return v, ttlMilis, nil
+}
+
+// 读取 key 对应的影子副本. 影子副本只存储在下一级缓存中
+func (c *Cache) GetShadow(ctx context.Context, key string) (string, error) {
+	return c.next.GetShadow(ctx, key)
+}
+
+// 查询某个 key 对应读流程写缓存机制是否启用. 本地处于禁用状态时直接返回未启用
+func (c *Cache) IsEnabled(ctx context.Context, key string) (bool, error) {
+	c.mu.Lock()
+	disabled := c.isDisabled(key)
+	c.mu.Unlock()
+	if disabled {
+		return false, nil
+	}
+	return c.next.IsEnabled(ctx, key)
+}
+
+// 读取 key 对应缓存. 本地缓存 miss 时读取下一级缓存，并由下一级缓存授予租约
+func (c *Cache) GetWithLease(ctx context.Context, key, token string, leaseMilis int64) (string, bool, error) {
+	if skipLocal(ctx) {
+		return c.next.GetWithLease(ctx, key, token, leaseMilis)
+	}
+	if v, ok := c.lru.get(key); ok {
+		consistent_cache.ReportCacheSource(ctx, consistent_cache.SourceL1)
+		return v, false, nil
+	}
+
+	epoch := c.epoch(key)
+	v, leased, err := c.next.GetWithLease(ctx, key, token, leaseMilis)
+	if err != nil {
+		return "", leased, err
+	}
+	c.fill(key, v, epoch)
+	return v, false, nil
+}
+
+// 删除 key 对应缓存
+func (c *Cache) Del(ctx context.Context, key string, opts ...consistent_cache.CacheOption) error {
+	if err := c.next.Del(ctx, key, opts...); err != nil {
+		return err
+	}
+	c.invalidate(key)
+	c.publish(ctx, opDel, 0, "", key)
+	return nil
+}
+
+// 校验某个 key 对应读流程写缓存机制是否启用，倘若启用则写入缓存. 只写入下一级缓存
+// 读操作指定了 ForceRefresh 时，写入成功后使各进程的本地缓存失效，避免继续读到刷新前的旧数据
+func (c *Cache) PutWhenEnable(ctx context.Context, key, value string, expireSeconds int64, opts ...consistent_cache.CacheOption) (bool, error) {
+	ok, err := c.next.PutWhenEnable(ctx, key, value, expireSeconds, opts...)
+	if ok && consistent_cache.GetOptionsFromContext(ctx).ForceRefresh {
+		c.invalidate(key)
+		c.publish(ctx, opDel, 0, "", key)
+	}
+	return ok, err
+}
+
+// 直接写入下一级缓存，并使本地缓存失效
+func (c *Cache) Set(ctx context.Context, key, value string, expireSeconds int64, opts ...consistent_cache.CacheOption) (bool, error) {
+	ok, err := c.next.Set(ctx, key, value, expireSeconds, opts...)
+	if err != nil {
+		return false, err
+	}
+	c.invalidate(key)
+	c.publish(ctx, opDel, 0, "", key)
+	return ok, nil
+}
+
+// 批量读取 keys 对应缓存，返回结果中只包含命中缓存的 key
+func (c *Cache) MGet(ctx context.Context, keys []string) (map[string]string, error) {
+	if skipLocal(ctx) {
+		return c.next.MGet(ctx, keys)
+	}
+
+	values := make(map[string]string, len(keys))
+	misses := make([]string, 0, len(keys))
+	epochs := make([]uint64, 0, len(keys))
+	for _, key := range keys {
+		if v, ok := c.lru.get(key); ok {
+			values[key] = v
+			continue
+		}
+		misses = append(misses, key)
+		epochs = append(epochs, c.epoch(key))
+	}
+
+	if len(misses) == 0 {
+		return values, nil
+	}
+
+	nextValues, err := c.next.MGet(ctx, misses)
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range misses {
+		v, ok := nextValues[key]
+		if !ok {
+			continue
+		}
+		c.fill(key, v, epochs[i])
+		values[key] = v
+	}
+	return values, nil
+}
+
+// 批量执行 PutWhenEnable. 只写入下一级缓存
+func (c *Cache) MPutWhenEnable(ctx context.Context, entries []consistent_cache.CacheEntry) ([]bool, error) {
+	return c.next.MPutWhenEnable(ctx, entries)
+}
+
+// 批量禁用 keys 对应读流程写缓存机制，并删除 keys 对应缓存
+func (c *Cache) MDisableAndDel(ctx context.Context, keys []string, token string, expireSeconds int64, opts ...consistent_cache.CacheOption) error {
+	for _, key := range keys {
+		c.disable(key, token, expireSeconds*1000)
+		c.publish(ctx, opDisable, expireSeconds*1000, token, key)
+	}
+	return c.next.MDisableAndDel(ctx, keys, token, expireSeconds, opts...)
+}
+
+// 批量撤销写流程 token 对 keys 的禁用. 只有下一级缓存撤销成功后才撤销本地禁用
+func (c *Cache) MEnable(ctx context.Context, keys []string, token string, delayMilis int64) error {
+	if err := c.next.MEnable(ctx, keys, token, delayMilis); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		c.enable(key, token, delayMilis)
+		c.publish(ctx, opEnable, delayMilis, token, key)
+	}
+	return nil
+}
+
+// 尝试获取 key 对应的缓存 miss 锁
+func (c *Cache) Lock(ctx context.Context, key, token string, expireMilis int64) (bool, error) {
+	return c.next.Lock(ctx, key, token, expireMilis)
+}
+
+// 释放 key 对应的缓存 miss 锁
+func (c *Cache) Unlock(ctx context.Context, key, token string) error {
+	return c.next.Unlock(ctx, key, token)
+}
+
+// 在版本号未发生变化且 key 未被禁用的前提下，将数据写入本地缓存
+func (c *Cache) fill(key, value string, epoch uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.epochs[shard(key)] != epoch || c.isDisabled(key) {
+		return
+	}
+	c.lru.put(key, value, c.opts.ttl)
+}
+
+// 以写流程 token 的身份本地禁用 key，并删除对应的本地缓存. 禁用在 expireMilis 后过期，防止 enable 操作丢失导致 key 一直处于禁用状态
+func (c *Cache) disable(key, token string, expireMilis int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tokens, ok := c.disabled[key]
+	if !ok {
+		tokens = make(map[string]time.Time)
+		c.disabled[key] = tokens
+	}
+	if until := time.Now().Add(time.Duration(expireMilis) * time.Millisecond); until.After(tokens[token]) {
+		tokens[token] = until
+	}
+	c.evict(key)
+}
+
+// 延时 delayMilis 后撤销写流程 token 对 key 的本地禁用. token 未禁用 key 时不做处理，重复的 enable 操作不会延长禁用时间
+func (c *Cache) enable(key, token string, delayMilis int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tokens, ok := c.disabled[key]
+	if !ok {
+		return
+	}
+	until, ok := tokens[token]
+	if !ok {
+		return
+	}
+	if deadline := time.Now().Add(time.Duration(delayMilis) * time.Millisecond); deadline.Before(until) {
+		tokens[token] = deadline
+	}
+}
+
+// 是否处于禁用状态：存在尚未过期的写流程 token. 调用方需要持有 c.mu
+func (c *Cache) isDisabled(key string) bool {
+	tokens, ok := c.disabled[key]
+	if !ok {
+		return false
+	}
+	now := time.Now()
+	for token, until := range tokens {
+		if now.After(until) {
+			delete(tokens, token)
+		}
+	}
+	if len(tokens) == 0 {
+		delete(c.disabled, key)
+		return false
+	}
+	return true
+}
+
+// 删除 key 对应的本地缓存
+func (c *Cache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evict(key)
+}
+
+// 删除 key 对应的本地缓存，并递增所在分段的版本号. 调用方需要持有 c.mu
+func (c *Cache) evict(key string) {
+	c.epochs[shard(key)]++
+	c.lru.del(key)
+}
+
+// 清空本地缓存，并递增全部分段的版本号
+func (c *Cache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.epochs {
+		c.epochs[i]++
+	}
+	c.lru.purge()
+}
+
+func (c *Cache) epoch(key string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epochs[shard(key)]
+}
+
+// 广播失效消息. 广播失败只会导致其他进程的本地缓存在过期前读到旧数据，因此只打印日志
+func (c *Cache) publish(ctx context.Context, op string, milis int64, token, key string) {
+	if c.opts.broker == nil {
+		return
+	}
+	if err := c.opts.broker.Publish(ctx, fmt.Sprintf("%s|%s|%d|%s|%s", c.id, op, milis, token, key)); err != nil {
+		c.opts.logger.Errorf("l1 publish fail, op: %s, key: %s, err: %v", op, key, err)
+	}
+}
+
+// 持续订阅失效消息. 订阅中断时会清空本地缓存，并在 1s 后重新订阅
+func (c *Cache) subscribe(ctx context.Context) {
+	defer close(c.done)
+	for {
+		err := c.opts.broker.Subscribe(ctx, c.handle)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// 订阅中断期间可能丢失失效消息，因此清空本地缓存
+		c.opts.logger.Errorf("l1 subscribe fail, err: %v", err)
+		c.purge()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// 处理其他进程广播的失效消息. 消息格式：{id}|{op}|{milis}|{token}|{key}
+func (c *Cache) handle(message string) {
+	parts := strings.SplitN(message, "|", 5)
+	if len(parts) != 5 {
+		c.opts.logger.Warnf("l1 receive invalid message: %s", message)
+		return
+	}
+	if parts[0] == c.id {
+		return
+	}
+
+	milis, _ := strconv.ParseInt(parts[2], 10, 64)
+	switch token, key := parts[3], parts[4]; parts[1] {
+	case opDisable:
+		c.disable(key, token, milis)
+	case opEnable:
+		c.enable(key, token, milis)
+	default:
+		c.invalidate(key)
+	}
+}
+
+// 读操作指定了 BypassCache 或 ForceRefresh 时，需要读取下一级缓存中的最新数据，不读也不写本地缓存
+func skipLocal(ctx context.Context) bool {
+	o := consistent_cache.GetOptionsFromContext(ctx)
+	return o.BypassCache || o.ForceRefresh
+}
+
+func shard(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % epochShards)
+}
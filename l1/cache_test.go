@@ -0,0 +1,225 @@
+package l1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/xiaoxuxiansheng/consistent_cache"
+	"github.com/xiaoxuxiansheng/consistent_cache/lib/log"
+	"github.com/xiaoxuxiansheng/consistent_cache/redis"
+)
+
+const channel = "l1_invalidation"
+
+func newCache(t *testing.T, mr *miniredis.Miniredis, opts ...Option) *Cache {
+	config := &redis.Config{Address: mr.Addr()}
+	c := NewCache(redis.NewRedisCache(config), append([]Option{
+		WithTTL(time.Minute),
+		WithBroker(redis.NewBroker(config, channel)),
+		WithLogger(log.NewNopLogger()),
+	}, opts...)...)
+	t.Cleanup(c.Close)
+	return c
+}
+
+// 验证点：命中本地缓存后不再读取下一级缓存，Del 后本地缓存失效
+func Test_Cache_Get(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := newCache(t, mr)
+	ctx := context.Background()
+
+	_ = mr.Set("key", "v1")
+	v, err := c.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", v)
+
+	// 下一级缓存中的数据发生变化，仍读取到本地缓存中的数据
+	_ = mr.Set("key", "v2")
+	v, err = c.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", v)
+
+	// Del 后本地缓存失效
+	assert.NoError(t, c.Del(ctx, "key"))
+	_ = mr.Set("key", "v3")
+	v, err = c.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "v3", v)
+}
+
+// 验证点：处于禁用状态的 key 不会写入本地缓存，enable 延时结束后恢复写入
+func Test_Cache_Disable(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := newCache(t, mr)
+	ctx := context.Background()
+
+	assert.NoError(t, c.Disable(ctx, "key", "writer", 10))
+	_ = mr.Set("key", "v1")
+	v, err := c.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", v)
+
+	// 禁用期间未写入本地缓存，读取到下一级缓存中的最新数据
+	_ = mr.Set("key", "v2")
+	v, err = c.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", v)
+
+	// enable 延时结束后，恢复写入本地缓存
+	assert.NoError(t, c.Enable(ctx, "key", "writer", 50))
+	<-time.After(100 * time.Millisecond)
+	_, _ = c.Get(ctx, "key")
+	_ = mr.Set("key", "v3")
+	v, err = c.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", v)
+}
+
+// 验证点：某个进程的 Del 操作通过广播使其他进程的本地缓存失效
+func Test_Cache_Broadcast(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c1, c2 := newCache(t, mr), newCache(t, mr)
+	ctx := context.Background()
+
+	// 等待订阅生效
+	<-time.After(100 * time.Millisecond)
+
+	_ = mr.Set("key", "v1")
+	v, err := c1.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", v)
+
+	// 通过 c2 删除缓存，c1 接收到广播后使本地缓存失效
+	assert.NoError(t, c2.Del(ctx, "key"))
+	_ = mr.Set("key", "v2")
+	<-time.After(100 * time.Millisecond)
+	v, err = c1.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", v)
+}
+
+// 本地是否处于禁用状态
+func localDisabled(c *Cache, key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.isDisabled(key)
+}
+
+// enable 操作失败的缓存模块
+type failEnableCache struct {
+	consistent_cache.Cache
+}
+
+func (failEnableCache) Enable(ctx context.Context, key, token string, delayMilis int64) error {
+	return errors.New("i/o timeout")
+}
+
+// 验证点：1 本地以及其他进程按写流程 token 记录禁用，同一写流程重复的 enable 操作不会撤销其他写流程的禁用
+// 2 下一级缓存 enable 失败时不撤销本地禁用
+func Test_Cache_OverlappingWriters(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c1, c2 := newCache(t, mr), newCache(t, mr)
+	ctx := context.Background()
+
+	// 等待订阅生效
+	<-time.After(100 * time.Millisecond)
+
+	assert.NoError(t, c1.Disable(ctx, "key", "writerA", 10))
+	assert.NoError(t, c1.Disable(ctx, "key", "writerB", 10))
+	// 写流程 A 的 enable 操作被重试多次
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, c1.Enable(ctx, "key", "writerA", 0))
+	}
+	<-time.After(100 * time.Millisecond)
+	assert.True(t, localDisabled(c1, "key"))
+	assert.True(t, localDisabled(c2, "key"))
+
+	assert.NoError(t, c1.Enable(ctx, "key", "writerB", 0))
+	<-time.After(100 * time.Millisecond)
+	assert.False(t, localDisabled(c1, "key"))
+	assert.False(t, localDisabled(c2, "key"))
+
+	c := NewCache(failEnableCache{Cache: redis.NewRedisCache(&redis.Config{Address: mr.Addr()})}, WithLogger(log.NewNopLogger()))
+	defer c.Close()
+	assert.NoError(t, c.Disable(ctx, "key", "writer", 10))
+	assert.Error(t, c.Enable(ctx, "key", "writer", 0))
+	assert.True(t, localDisabled(c, "key"))
+}
+
+type kvObject struct {
+	K string `json:"k"`
+	V string `json:"v"`
+}
+
+func (o *kvObject) KeyColumn() string { return "k" }
+func (o *kvObject) Key() string       { return o.K }
+func (o *kvObject) Write() (string, error) {
+	body, err := json.Marshal(o)
+	return string(body), err
+}
+func (o *kvObject) Read(body string) error { return json.Unmarshal([]byte(body), o) }
+
+// 内存实现的数据库模块，只支持读操作
+type kvDB struct {
+	consistent_cache.DB
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (d *kvDB) Get(ctx context.Context, obj consistent_cache.Object) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	obj.(*kvObject).V = d.values[obj.Key()]
+	return nil
+}
+
+func (d *kvDB) set(key, value string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.values[key] = value
+}
+
+// 验证点：1 BypassCache 读取 db 且不影响本地缓存 2 ForceRefresh 写缓存后使各进程的本地缓存失效，后续读操作不再读到刷新前的旧数据
+func Test_Cache_GetOptions(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c1, c2 := newCache(t, mr), newCache(t, mr)
+	db := &kvDB{values: map[string]string{"key": "1"}}
+	s1 := consistent_cache.NewService(c1, db, consistent_cache.WithLogger(log.NewNopLogger()))
+	defer s1.Close()
+	s2 := consistent_cache.NewService(c2, db, consistent_cache.WithLogger(log.NewNopLogger()))
+	defer s2.Close()
+	ctx := context.Background()
+
+	// 等待订阅生效
+	<-time.After(100 * time.Millisecond)
+
+	get := func(s *consistent_cache.Service, opts ...consistent_cache.GetOption) string {
+		obj := &kvObject{K: "key"}
+		_, err := s.Get(ctx, obj, opts...)
+		assert.NoError(t, err)
+		return obj.V
+	}
+
+	// 第一次读操作写入下一级缓存，第二次读操作写入本地缓存
+	for _, s := range []*consistent_cache.Service{s1, s2} {
+		assert.Equal(t, "1", get(s))
+		assert.Equal(t, "1", get(s))
+	}
+
+	// BypassCache 读取 db 中的最新数据，但不写缓存
+	db.set("key", "2")
+	assert.Equal(t, "2", get(s1, consistent_cache.BypassCache()))
+	assert.Equal(t, "1", get(s1))
+
+	// ForceRefresh 写缓存后，当前进程与其他进程的本地缓存均失效
+	assert.Equal(t, "2", get(s1, consistent_cache.ForceRefresh()))
+	assert.Equal(t, "2", get(s1))
+	<-time.After(100 * time.Millisecond)
+	assert.Equal(t, "2", get(s2))
+}
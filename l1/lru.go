@@ -0,0 +1,92 @@
+package l1
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lru 中的一笔数据
+type entry struct {
+	key      string
+	value    string
+	expireAt time.Time
+}
+
+// 容量有限，且数据带有过期时间的 lru 缓存
+type lru struct {
+	mu       sync.Mutex
+	capacity int
+	// 链表头部为最近访问的数据
+	list  *list.List
+	index map[string]*list.Element
+}
+
+func newLRU(capacity int) *lru {
+	return &lru{
+		capacity: capacity,
+		list:     list.New(),
+		index:    make(map[string]*list.Element, capacity),
+	}
+}
+
+// 读取数据. 数据不存在或者已过期时返回 false
+func (l *lru) get(key string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	elem, ok := l.index[key]
+	if !ok {
+		return "", false
+	}
+	e, _ := elem.Value.(*entry)
+	if time.Now().After(e.expireAt) {
+		l.removeElement(elem)
+		return "", false
+	}
+	l.list.MoveToFront(elem)
+	return e.value, true
+}
+
+// 写入数据. 容量达到上限时淘汰最久未访问的数据
+func (l *lru) put(key, value string, ttl time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.index[key]; ok {
+		e, _ := elem.Value.(*entry)
+		e.value, e.expireAt = value, time.Now().Add(ttl)
+		l.list.MoveToFront(elem)
+		return
+	}
+
+	l.index[key] = l.list.PushFront(&entry{key: key, value: value, expireAt: time.Now().Add(ttl)})
+	for l.list.Len() > l.capacity {
+		l.removeElement(l.list.Back())
+	}
+}
+
+// 删除数据
+func (l *lru) del(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.index[key]; ok {
+		l.removeElement(elem)
+	}
+}
+
+// 清空全部数据
+func (l *lru) purge() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.list.Init()
+	l.index = make(map[string]*list.Element, l.capacity)
+}
+
+func (l *lru) removeElement(elem *list.Element) {
+	e, _ := elem.Value.(*entry)
+	delete(l.index, e.key)
+	l.list.Remove(elem)
+}
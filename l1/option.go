@@ -0,0 +1,67 @@
+package l1
+
+import (
+	"time"
+
+	"github.com/xiaoxuxiansheng/consistent_cache"
+	"github.com/xiaoxuxiansheng/consistent_cache/lib/log"
+)
+
+type Options struct {
+	// 本地缓存最多存储的数据条数
+	capacity int
+	// 本地缓存过期时间
+	ttl time.Duration
+	// 失效消息广播模块. 为空时只在本地失效，不通知其他进程
+	broker Broker
+	// 日志打印
+	logger consistent_cache.Logger
+}
+
+type Option func(*Options)
+
+const (
+	// 默认的本地缓存容量为 10000 条
+	DefaultCapacity = 10000
+	// 默认的本地缓存过期时间为 1 s
+	DefaultTTL = time.Second
+)
+
+func WithCapacity(capacity int) Option {
+	return func(o *Options) {
+		o.capacity = capacity
+	}
+}
+
+func WithTTL(ttl time.Duration) Option {
+	return func(o *Options) {
+		o.ttl = ttl
+	}
+}
+
+// 通过 broker 在进程间广播失效消息，保证某个进程的写操作能够使其他进程的本地缓存失效
+func WithBroker(broker Broker) Option {
+	return func(o *Options) {
+		o.broker = broker
+	}
+}
+
+func WithLogger(logger consistent_cache.Logger) Option {
+	return func(o *Options) {
+		o.logger = logger
+	}
+}
+
+func repair(o *Options) {
+	if o.capacity <= 0 {
+		o.capacity = DefaultCapacity
+	}
+
+	if o.ttl <= 0 {
+		o.ttl = DefaultTTL
+	}
+
+	if o.logger == nil {
+		o.logger = log.GetLogger()
+	}
+}
@@ -0,0 +1,73 @@
+package consistent_cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/xiaoxuxiansheng/consistent_cache/lib/runtime"
+)
+
+// 租约模式下的读流程
+func (s *Service) getWithLease(ctx context.Context, obj Object, loader func(ctx context.Context) error) (useCache bool, err error) {
+	// 1 读取缓存，缓存 miss 时尝试获取租约. 租约 token 在调用方维度唯一
+	token := runtime.GenerateUniqueID()
+	start := time.Now()
+	v, leased, err := s.cache.GetWithLease(ctx, obj.Key(), token, s.opts.leaseMilis)
+	getRecorderFrom(ctx).recordCache(time.Since(start), -1, err == nil)
+	// 2 缓存不可用时直接加载数据，且不写缓存
+	if errors.Is(err, ErrorCacheUnavailable) {
+		return false, s.loadOnly(ctx, obj, loader)
+	}
+	// 非缓存 miss 类错误，直接抛出错误
+	if err != nil && !errors.Is(err, ErrorCacheMiss) {
+		return false, err
+	}
+
+	// 3 读取到缓存结果
+	if err == nil {
+		v, _ = s.openEnvelope(v)
+		// 调用方不使用负缓存时，NullData 视为缓存 miss. 由于未持有租约，直接加载数据且不写缓存
+		if v == NullData && GetOptionsFromContext(ctx).NoNegativeCache {
+			return false, s.loadOnly(ctx, obj, loader)
+		}
+		return true, readCache(obj, v)
+	}
+
+	// 4 获得租约，加载数据并凭租约写缓存. 倘若期间租约被写流程撤销，则写缓存会被拒绝
+	if leased {
+		_, err = s.loadAndFill(ctx, obj, loader, WithLeaseToken(token))
+		return false, err
+	}
+
+	// 5 租约被其他调用方持有，在限定时间内轮询缓存，等待持有租约的调用方写缓存
+	if s.opts.leaseWaitMilis > 0 {
+		_, err := s.pollCache(ctx, obj, s.opts.leaseWaitMilis, DefaultLeasePollMilis)
+		if err == nil || errors.Is(err, ErrorDataNotExist) {
+			return true, err
+		}
+		if !errors.Is(err, ErrorCacheMiss) {
+			return false, err
+		}
+	}
+
+	// 6 轮询超时或未开启轮询，直接加载数据. 由于未持有租约，不写缓存
+	return false, s.loadOnly(ctx, obj, loader)
+}
+
+// 租约模式下的写流程：执行写操作 -> 删除缓存并撤销 key 的全部租约
+func (s *Service) writeWithLease(ctx context.Context, key string, write func(ctx context.Context) error, delOpts ...CacheOption) error {
+	var err error
+	if write != nil {
+		err = write(ctx)
+	}
+
+	// 即便写操作失败，也可能已经部分生效（例如超时），因此仍然删除缓存
+	if delErr := s.cache.Del(ctx, key, delOpts...); delErr != nil {
+		s.opts.logger.Errorf("del cache with lease mode fail, key: %s, err: %v", key, delErr)
+		if err == nil {
+			err = delErr
+		}
+	}
+	return err
+}
@@ -105,3 +105,8 @@ func (w *ZapLoggerWrapper) getLogWriter() zapcore.WriteSyncer {
 		Compress:   w.options.Compress,
 	})
 }
+
+// NewNopLogger 不输出任何内容的日志模块，供测试使用
+func NewNopLogger() *ZapLoggerWrapper {
+	return &ZapLoggerWrapper{SugaredLogger: zap.NewNop().Sugar()}
+}
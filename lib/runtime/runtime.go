@@ -5,6 +5,7 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"sync/atomic"
 )
 
 // 生成由当前进程 id 和协程 id 组成的标识字符串
@@ -26,3 +27,13 @@ func GetCurrentGoroutineID() string {
 func GetCurrentProcessID() int {
 	return os.Getpid()
 }
+
+var (
+	hostname, _ = os.Hostname()
+	sequence    uint64
+)
+
+// 生成全局唯一的标识字符串，由主机名、进程 id、协程 id 以及进程内自增序号组成
+func GenerateUniqueID() string {
+	return fmt.Sprintf("%s_%s_%d", hostname, GetCurrentProcessAndGogroutineIDStr(), atomic.AddUint64(&sequence, 1))
+}
@@ -0,0 +1,59 @@
+package singleflight
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// fn 执行过程中发生 panic 时，等待方获取到的错误
+var ErrorPanic = errors.New("singleflight: fn panicked")
+
+// 一次正在执行中的调用
+type call struct {
+	// 调用结束时关闭
+	done chan struct{}
+	// 调用结果
+	val string
+	err error
+}
+
+// 针对相同 key 的并发调用进行合并，同一时刻只有一个调用方真正执行
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+func NewGroup() *Group {
+	return &Group{calls: make(map[string]*call)}
+}
+
+// 倘若 key 不存在执行中的调用，则由当前调用方同步执行 fn；否则等待执行中的调用结束并共享其结果.
+// 等待期间倘若当前调用方的 ctx 终止，则立即返回 ctx 对应的错误，不影响执行中的调用
+func (g *Group) Do(ctx context.Context, key string, fn func() (string, error)) (string, error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-c.done:
+			return c.val, c.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	c := call{done: make(chan struct{}), err: ErrorPanic}
+	g.calls[key] = &c
+	g.mu.Unlock()
+
+	// 即便 fn 发生 panic，也要保证等待方能够正常退出
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+
+	c.val, c.err = fn()
+	return c.val, c.err
+}
@@ -0,0 +1,72 @@
+package singleflight
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 验证点：相同 key 的并发调用只执行一次 fn，且全部调用方共享结果
+func Test_Group_Do(t *testing.T) {
+	group := NewGroup()
+	ctx := context.Background()
+
+	var calls int32
+	release := make(chan struct{})
+	fn := func() (string, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "v", nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := group.Do(ctx, "key", fn)
+			assert.NoError(t, err)
+			assert.Equal(t, "v", v)
+		}()
+	}
+
+	// 等待全部调用方进入 Do 后再放行
+	<-time.After(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+// 验证点：等待方 ctx 终止时立即返回，不影响执行中的调用
+func Test_Group_Do_Cancel(t *testing.T) {
+	group := NewGroup()
+
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		v, err := group.Do(context.Background(), "key", func() (string, error) {
+			<-release
+			return "v", nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "v", v)
+	}()
+
+	<-time.After(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := group.Do(ctx, "key", func() (string, error) {
+		t.Error("fn should not be called")
+		return "", nil
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	close(release)
+	<-done
+}
@@ -0,0 +1,77 @@
+package consistent_cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/xiaoxuxiansheng/consistent_cache/lib/runtime"
+)
+
+// 在分布式缓存 miss 锁的保护下加载数据并写缓存. 返回 obj 序列化后的结果
+func (s *Service) loadWithLock(ctx context.Context, obj Object, loader func(ctx context.Context) error) (string, error) {
+	// 1 抢锁. 锁的 token 在调用方维度唯一
+	token := runtime.GenerateUniqueID()
+	locked, err := s.cache.Lock(ctx, obj.Key(), token, s.opts.missLockExpireMilis)
+	// 1.1 抢锁失败不影响读流程，直接加载数据
+	if err != nil {
+		s.opts.logger.Errorf("lock miss key fail, key: %s, err: %v", obj.Key(), err)
+		return s.loadAndFill(ctx, obj, loader)
+	}
+
+	// 2 抢锁成功，加载数据并写缓存，完成后释放锁. 调用方的 ctx 可能已经终止，因此使用独立的 ctx 释放锁，避免其他调用方等待锁过期
+	if locked {
+		defer func() {
+			uctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := s.cache.Unlock(uctx, obj.Key(), token); err != nil {
+				s.opts.logger.Errorf("unlock miss key fail, key: %s, err: %v", obj.Key(), err)
+			}
+		}()
+		return s.loadAndFill(ctx, obj, loader)
+	}
+
+	// 3 未抢到锁，在限定时间内轮询缓存，等待持有锁的调用方写缓存
+	if s.opts.missLockWaitMilis > 0 {
+		v, err := s.pollCache(ctx, obj, s.opts.missLockWaitMilis, s.opts.missLockPollMilis)
+		if err == nil || errors.Is(err, ErrorDataNotExist) {
+			return v, err
+		}
+		if !errors.Is(err, ErrorCacheMiss) {
+			return "", err
+		}
+	}
+
+	// 4 轮询超时或未开启轮询，直接加载数据
+	return s.loadAndFill(ctx, obj, loader)
+}
+
+// 每隔 pollMilis 轮询一次缓存，直到读取到结果或者等待超过 waitMilis. 等待超时返回 ErrorCacheMiss
+func (s *Service) pollCache(ctx context.Context, obj Object, waitMilis, pollMilis int64) (string, error) {
+	timer := time.NewTimer(time.Duration(waitMilis) * time.Millisecond)
+	defer timer.Stop()
+	ticker := time.NewTicker(time.Duration(pollMilis) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-timer.C:
+			return "", ErrorCacheMiss
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+		v, err := s.cache.Get(ctx, obj.Key())
+		getRecorderFrom(ctx).recordCache(time.Since(start), -1, err == nil)
+		if errors.Is(err, ErrorCacheMiss) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		v, _ = s.openEnvelope(v)
+		return v, readCache(obj, v)
+	}
+}
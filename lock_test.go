@@ -0,0 +1,37 @@
+package consistent_cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 记录释放缓存 miss 锁时 ctx 状态的缓存模块
+type unlockRecordCache struct {
+	*memoryCache
+	unlockErrs []error
+}
+
+func (c *unlockRecordCache) Lock(ctx context.Context, key, token string, expireMilis int64) (bool, error) {
+	return true, nil
+}
+
+func (c *unlockRecordCache) Unlock(ctx context.Context, key, token string) error {
+	c.unlockErrs = append(c.unlockErrs, ctx.Err())
+	return ctx.Err()
+}
+
+// 验证点：加载数据期间调用方的 ctx 终止，缓存 miss 锁仍然能够释放
+func Test_MissLock_UnlockAfterCancel(t *testing.T) {
+	cache := &unlockRecordCache{memoryCache: newMemoryCache()}
+	service := newTestService(cache, nil, WithMissLock(1000))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	_, err := service.GetOrLoad(ctx, &counterObject{K: "key"}, func(ctx context.Context) error {
+		cancel()
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []error{nil}, cache.unlockErrs)
+}
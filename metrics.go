@@ -0,0 +1,41 @@
+package consistent_cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// 不上报任何指标的监控模块
+type noopMetrics struct{}
+
+func (noopMetrics) ObserveGet(result string)                                  {}
+func (noopMetrics) ObserveFill(accepted bool)                                 {}
+func (noopMetrics) ObservePutStep(step string, cost time.Duration, err error) {}
+func (noopMetrics) ObserveEnable(cost time.Duration, err error)               {}
+
+// 根据读操作的返回结果上报读操作指标. 加载数据失败且未降级返回影子副本的读操作不上报
+func (s *Service) observeGet(info GetInfo, err error) {
+	switch {
+	case err == nil && info.Degraded:
+		s.opts.metrics.ObserveGet(GetResultDegraded)
+	case err == nil && info.UseCache:
+		s.opts.metrics.ObserveGet(GetResultHit)
+	case err == nil:
+		s.opts.metrics.ObserveGet(GetResultMiss)
+	case errors.Is(err, ErrorDataNotExist) && info.UseCache:
+		s.opts.metrics.ObserveGet(GetResultNullHit)
+	case errors.Is(err, ErrorDataNotExist):
+		s.opts.metrics.ObserveGet(GetResultDBMiss)
+	}
+}
+
+// 按照重试策略执行写流程的单个步骤，并上报步骤耗时. 开启链路追踪时每个步骤对应一个子 span
+func (s *Service) step(ctx context.Context, name string, f func(ctx context.Context) error) error {
+	ctx, span := s.opts.tracer.Start(ctx, "consistent_cache."+name)
+	start := time.Now()
+	err := s.retry(ctx, f)
+	s.opts.metrics.ObservePutStep(name, time.Since(start), err)
+	endSpan(span, err)
+	return err
+}
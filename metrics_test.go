@@ -0,0 +1,70 @@
+package consistent_cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 记录上报结果的监控模块
+type recordMetrics struct {
+	mu    sync.Mutex
+	gets  []string
+	fills []bool
+	steps []string
+}
+
+func (m *recordMetrics) ObserveGet(result string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gets = append(m.gets, result)
+}
+
+func (m *recordMetrics) ObserveFill(accepted bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fills = append(m.fills, accepted)
+}
+
+func (m *recordMetrics) ObservePutStep(step string, cost time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.steps = append(m.steps, step)
+}
+
+func (m *recordMetrics) ObserveEnable(cost time.Duration, err error) {}
+
+// 验证点：1 读操作按结果上报 miss、hit、null_hit、db_miss 2 写缓存被拒绝时上报 fill 失败 3 写操作上报每个步骤
+func Test_Metrics(t *testing.T) {
+	metrics := &recordMetrics{}
+	cache := newMemoryCache()
+	service := newTestService(cache, &counterDB{count: "1"}, WithMetrics(metrics))
+	ctx := context.Background()
+
+	_, err := service.Get(ctx, &counterObject{K: "key"})
+	assert.NoError(t, err)
+	_, err = service.Get(ctx, &counterObject{K: "key"})
+	assert.NoError(t, err)
+	cache.set("null", NullData)
+	_, err = service.Get(ctx, &counterObject{K: "null"})
+	assert.ErrorIs(t, err, ErrorDataNotExist)
+	cache.setEnabled(false)
+	_, err = service.Get(ctx, &counterObject{K: "other"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{GetResultMiss, GetResultHit, GetResultNullHit, GetResultMiss}, metrics.gets)
+	assert.Equal(t, []bool{true, false}, metrics.fills)
+
+	metrics = &recordMetrics{}
+	service = newTestService(newMemoryCache(), emptyDB{}, WithMetrics(metrics))
+	_, err = service.Get(ctx, &counterObject{K: "key"})
+	assert.ErrorIs(t, err, ErrorDataNotExist)
+	assert.Equal(t, []string{GetResultDBMiss}, metrics.gets)
+	assert.Equal(t, []bool{true}, metrics.fills)
+
+	service = newTestService(&delRecordCache{}, putOnlyDB{}, WithStrategy(StrategyDoubleDelete), WithMetrics(metrics))
+	assert.NoError(t, service.Put(ctx, &strategyObject{key: "key"}))
+	assert.Equal(t, []string{PutStepDel, PutStepWrite}, metrics.steps)
+}
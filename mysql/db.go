@@ -4,8 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
 
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/xiaoxuxiansheng/consistent_cache"
 )
@@ -14,50 +17,189 @@ type tabler interface {
 	TableName() string
 }
 
+// 倘若 obj 是对其他数据模型的包装，则实际通过 Model 方法获取的数据模型进行读写
+type modeler interface {
+	Model() interface{}
+}
+
 // 数据库模块的抽象接口定义
 type DB struct {
-	db *gorm.DB
+	db     *gorm.DB
+	tracer trace.Tracer
 }
 
-func NewDB(dsn string) *DB {
-	return &DB{db: getDB(dsn)}
+func NewDB(dsn string, opts ...Option) *DB {
+	o := Options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	repair(&o)
+
+	return &DB{db: getDB(dsn), tracer: o.tracerProvider.Tracer(tracerName)}
 }
 
 // 数据写入数据库
-func (d *DB) Put(ctx context.Context, obj consistent_cache.Object) error {
-	db := d.db
-	tabler, ok := obj.(tabler)
-	if ok {
-		db = db.Table(tabler.TableName())
-	}
+func (d *DB) Put(ctx context.Context, obj consistent_cache.Object) (err error) {
+	ctx, span := d.startSpan(ctx, "Put", obj.Key())
+	defer func() { endSpan(span, err) }()
+
+	db := d.table(obj)
 
 	// 此处通过两个非原子性动作实现 upsert 效果：
 	// 1 尝试创建记录
 	// 2 倘若发生唯一键冲突，则改为执行更新操作
-	err := db.WithContext(ctx).Create(obj).Error
+	err = db.WithContext(ctx).Create(model(obj)).Error
 	if err == nil {
 		return nil
 	}
 
 	// 判断是否为唯一键冲突，若是的话，则改为更新操作
 	if IsDuplicateEntryErr(err) {
-		return db.WithContext(ctx).Debug().Where(fmt.Sprintf("`%s` = ?", obj.KeyColumn()), obj.Key()).Updates(obj).Error
+		return db.WithContext(ctx).Debug().Where(fmt.Sprintf("`%s` = ?", obj.KeyColumn()), obj.Key()).Updates(model(obj)).Error
 	}
 	// 其他错误直接返回
 	return err
 }
 
 // 从数据库读取数据
-func (d *DB) Get(ctx context.Context, obj consistent_cache.Object) error {
-	db := d.db
-	tabler, ok := obj.(tabler)
-	if ok {
-		db = db.Table(tabler.TableName())
-	}
+func (d *DB) Get(ctx context.Context, obj consistent_cache.Object) (err error) {
+	ctx, span := d.startSpan(ctx, "Get", obj.Key())
+	defer func() { endSpan(span, err) }()
 
-	err := db.WithContext(ctx).Where(fmt.Sprintf("`%s` = ?", obj.KeyColumn()), obj.Key()).First(obj).Error
+	db := d.table(obj)
+
+	err = db.WithContext(ctx).Where(fmt.Sprintf("`%s` = ?", obj.KeyColumn()), obj.Key()).First(model(obj)).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return consistent_cache.ErrorDBMiss
 	}
 	return err
 }
+
+// 从数据库删除数据
+func (d *DB) Delete(ctx context.Context, obj consistent_cache.Object) (err error) {
+	ctx, span := d.startSpan(ctx, "Delete", obj.Key())
+	defer func() { endSpan(span, err) }()
+
+	db := d.table(obj)
+
+	// 记录不存在时视为删除成功，保证删除操作的幂等性
+	return db.WithContext(ctx).Where(fmt.Sprintf("`%s` = ?", obj.KeyColumn()), obj.Key()).Delete(model(obj)).Error
+}
+
+// 从数据库批量读取数据. 要求 objs 中的数据均为相同类型的指针，对应同一张表
+func (d *DB) MGet(ctx context.Context, objs []consistent_cache.Object) (_ []bool, err error) {
+	ctx, span := d.startSpan(ctx, "MGet", keysOf(objs)...)
+	defer func() { endSpan(span, err) }()
+
+	exists := make([]bool, len(objs))
+	if len(objs) == 0 {
+		return exists, nil
+	}
+
+	typ := reflect.TypeOf(objs[0])
+	if typ.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("mget obj must be pointer, got: %s", typ)
+	}
+	if _, ok := objs[0].(modeler); ok {
+		return nil, fmt.Errorf("mget not support modeler obj: %s", typ)
+	}
+
+	keys := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		if reflect.TypeOf(obj) != typ {
+			return nil, fmt.Errorf("mget objs must be the same type, got: %s and %s", typ, reflect.TypeOf(obj))
+		}
+		keys = append(keys, obj.Key())
+	}
+
+	// 构造与 obj 相同类型的切片，通过一次 IN 查询接收全部结果
+	records := reflect.New(reflect.SliceOf(typ))
+	db := d.table(objs[0])
+	if err := db.WithContext(ctx).Where(fmt.Sprintf("`%s` IN ?", objs[0].KeyColumn()), keys).Find(records.Interface()).Error; err != nil {
+		return nil, err
+	}
+
+	// 按照 key 建立索引，再将查询结果回填到对应的 obj 中
+	index := make(map[string]reflect.Value, records.Elem().Len())
+	for i := 0; i < records.Elem().Len(); i++ {
+		record := records.Elem().Index(i)
+		index[record.Interface().(consistent_cache.Object).Key()] = record
+	}
+	for i, obj := range objs {
+		record, ok := index[obj.Key()]
+		if !ok {
+			continue
+		}
+		reflect.ValueOf(obj).Elem().Set(record.Elem())
+		exists[i] = true
+	}
+	return exists, nil
+}
+
+// 数据批量写入数据库. 要求 objs 中的数据均为相同类型的指针，对应同一张表
+func (d *DB) MPut(ctx context.Context, objs []consistent_cache.Object) []error {
+	ctx, span := d.startSpan(ctx, "MPut", keysOf(objs)...)
+	defer span.End()
+
+	errs := make([]error, len(objs))
+	if len(objs) == 0 {
+		return errs
+	}
+
+	typ := reflect.TypeOf(objs[0])
+	if typ.Kind() != reflect.Ptr {
+		return fillErrs(errs, fmt.Errorf("mput obj must be pointer, got: %s", typ))
+	}
+	if _, ok := objs[0].(modeler); ok {
+		return fillErrs(errs, fmt.Errorf("mput not support modeler obj: %s", typ))
+	}
+
+	// 构造与 obj 相同类型的切片，通过一条 INSERT ... ON DUPLICATE KEY UPDATE 语句完成批量 upsert
+	records := reflect.MakeSlice(reflect.SliceOf(typ), 0, len(objs))
+	for _, obj := range objs {
+		if reflect.TypeOf(obj) != typ {
+			return fillErrs(errs, fmt.Errorf("mput objs must be the same type, got: %s and %s", typ, reflect.TypeOf(obj)))
+		}
+		records = reflect.Append(records, reflect.ValueOf(obj))
+	}
+
+	db := d.table(objs[0])
+	err := db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(records.Interface()).Error
+	if err == nil {
+		return errs
+	}
+
+	// 批量写入失败时，降级为逐笔写入，从而定位到具体出错的数据
+	for i, obj := range objs {
+		errs[i] = d.Put(ctx, obj)
+	}
+	return errs
+}
+
+// 使用同一个错误填充 errs 中的每一项
+func fillErrs(errs []error, err error) []error {
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
+
+// 倘若 obj 或者其包装的数据模型声明了表名，则使用对应的表
+func (d *DB) table(obj consistent_cache.Object) *gorm.DB {
+	db := d.db
+	if tabler, ok := obj.(tabler); ok {
+		return db.Table(tabler.TableName())
+	}
+	if tabler, ok := model(obj).(tabler); ok {
+		return db.Table(tabler.TableName())
+	}
+	return db
+}
+
+// 获取 obj 实际对应的数据模型
+func model(obj consistent_cache.Object) interface{} {
+	if modeler, ok := obj.(modeler); ok {
+		return modeler.Model()
+	}
+	return obj
+}
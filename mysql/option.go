@@ -0,0 +1,25 @@
+package mysql
+
+import (
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Options struct {
+	// 链路追踪. 为空时不进行链路追踪
+	tracerProvider trace.TracerProvider
+}
+
+type Option func(*Options)
+
+// 开启链路追踪，为每次数据库操作创建 span
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(o *Options) {
+		o.tracerProvider = provider
+	}
+}
+
+func repair(o *Options) {
+	if o.tracerProvider == nil {
+		o.tracerProvider = trace.NewNoopTracerProvider()
+	}
+}
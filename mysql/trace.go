@@ -0,0 +1,46 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/xiaoxuxiansheng/consistent_cache"
+)
+
+const tracerName = "github.com/xiaoxuxiansheng/consistent_cache/mysql"
+
+// 为一次数据库操作开启 span. keys 为本次操作涉及的 key
+func (d *DB) startSpan(ctx context.Context, operation string, keys ...string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system", "mysql"),
+		attribute.String("db.operation", operation),
+	}
+	if len(keys) == 1 {
+		attrs = append(attrs, attribute.String("cache.key", keys[0]))
+	} else {
+		attrs = append(attrs, attribute.Int("cache.key_count", len(keys)))
+	}
+	return d.tracer.Start(ctx, "mysql."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// 结束 span. 数据不存在不视为错误
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, consistent_cache.ErrorDBMiss) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// 从 objs 中提取 key
+func keysOf(objs []consistent_cache.Object) []string {
+	keys := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		keys = append(keys, obj.Key())
+	}
+	return keys
+}
@@ -1,9 +1,12 @@
 package consistent_cache
 
 import (
+	"context"
 	"math/rand"
 	"time"
 
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/xiaoxuxiansheng/consistent_cache/lib/log"
 )
 
@@ -16,6 +19,48 @@ type Options struct {
 	disableExpireSeconds int64
 	// 写流程 disable 操作后延时多长时间进行 enable 操作，单位：毫秒
 	enableDelayMilis int64
+	// 一致性策略的类型
+	strategyType StrategyType
+	// 是否开启写穿模式
+	writeThrough bool
+	// 延时双删策略中，第二次删除缓存的延时时间，单位：毫秒
+	doubleDeleteDelayMilis int64
+	// 是否合并相同 key 的并发缓存 miss
+	missCoalescing bool
+	// 缓存 miss 锁的过期时间，单位：毫秒. 大于 0 时开启分布式缓存 miss 锁
+	missLockExpireMilis int64
+	// 未抢到缓存 miss 锁时，轮询缓存的最长等待时间，单位：毫秒. 为 0 时直接读 db
+	missLockWaitMilis int64
+	// 未抢到缓存 miss 锁时，轮询缓存的时间间隔，单位：毫秒
+	missLockPollMilis int64
+	// 写流程留下的版本号墓碑的过期时间，单位：秒
+	versionTombstoneExpireSeconds int64
+	// 租约的有效期，单位：毫秒. 大于 0 时开启租约模式
+	leaseMilis int64
+	// 未获得租约时，轮询缓存的最长等待时间，单位：毫秒. 为 0 时直接读 db
+	leaseWaitMilis int64
+	// 延时任务队列. 非空时写流程的延时 enable 操作会持久化到队列中执行
+	delayQueue DelayQueue
+	// 软过期后继续返回过期数据的时长，单位：秒. 大于 0 时开启 stale-while-revalidate
+	staleSeconds int64
+	// 提前刷新的激进程度. 大于 0 时开启基于 XFetch 算法的概率性提前刷新
+	earlyRefreshBeta float64
+	// 影子副本的过期时间，单位：秒. 大于 0 时开启 fail-static 模式
+	shadowExpireSeconds int64
+	// 重试策略. 为空时不进行重试
+	retryPolicy RetryPolicy
+	// 读写操作的拦截器
+	getInterceptors []GetInterceptor
+	putInterceptors []PutInterceptor
+	// 监控指标上报模块
+	metrics Metrics
+	// 链路追踪. 为空时不进行链路追踪
+	tracerProvider trace.TracerProvider
+	tracer         trace.Tracer
+	// 写回模式的缓冲区. 非空时开启写回模式
+	writeBuffer WriteBuffer
+	// 写回模式下，基于缓冲区中的变更构造 Object 的工厂函数
+	newObject func() Object
 	// 随机数生成器
 	rander *rand.Rand
 	// 日志打印
@@ -40,6 +85,33 @@ const (
 	DefaultDisableExpireSeconds = 10
 	// 默认的延时 enable 时间为 1 s
 	DefaultEnableDelayMilis = 1000
+	// 默认的延时双删时间为 1 s
+	DefaultDoubleDeleteDelayMilis = 1000
+	// 默认的缓存 miss 锁轮询间隔为 20 ms
+	DefaultMissLockPollMilis = 20
+	// 默认的租约轮询间隔为 20 ms
+	DefaultLeasePollMilis = 20
+	// 默认的延时任务队列轮询间隔为 100 ms
+	DefaultDelayQueuePollMilis = 100
+	// 默认每次从延时任务队列中领取至多 100 个任务
+	DefaultDelayQueueClaimLimit = 100
+	// 默认领取的任务在 5 s 内未确认完成，则重新到期
+	DefaultDelayQueueClaimMilis = 5000
+	// 默认的任务重试退避时间从 100 ms 开始指数增长，至多 10 s
+	DefaultDelayQueueRetryMilis    = 100
+	DefaultDelayQueueMaxRetryMilis = 10000
+	// 默认后台刷新过期数据的超时时间为 3 s
+	DefaultStaleRefreshTimeoutMilis = 3000
+	// 默认的写回间隔为 100 ms
+	DefaultWriteBehindFlushMilis = 100
+	// 默认每次从写回缓冲区中读取至多 500 笔变更
+	DefaultWriteBehindBatchSize = 500
+	// 默认的写回锁过期时间为 10 s
+	DefaultWriteBehindLockMilis = 10000
+	// 默认 Close 时最多等待 5 s 将缓冲区中的变更写入 db
+	DefaultWriteBehindCloseMilis = 5000
+	// 默认写回模式下，变更写入 db 之前禁用读流程写缓存的时间为 10 min
+	DefaultWriteBehindDisableSeconds = 600
 )
 
 func WithCacheExpireSeconds(cacheExpireSeconds int64) Option {
@@ -67,6 +139,155 @@ func WithEnableDelayMilis(enableDelayMilis int64) Option {
 	}
 }
 
+// 选择一致性策略，默认为 StrategyDisableEnable
+func WithStrategy(strategyType StrategyType) Option {
+	return func(o *Options) {
+		o.strategyType = strategyType
+	}
+}
+
+// 设置延时双删策略中第二次删除缓存的延时时间，需要大于读流程中一次读 db 并写缓存的最长耗时
+// Object 可以通过实现 DoubleDeleteDelayer 接口单独指定延时时间
+func WithDoubleDeleteDelayMilis(delayMilis int64) Option {
+	return func(o *Options) {
+		o.doubleDeleteDelayMilis = delayMilis
+	}
+}
+
+// 开启写穿模式. 写操作成功写入 db 后，将数据写入缓存，使后续读操作直接命中缓存
+// 只有在没有其他写流程与当前写流程并发时才会写缓存，否则保持缓存删除的状态，因此缓存中不会留下比 db 更旧的数据
+// 仅在 StrategyDisableEnable 策略下生效，租约模式下不生效
+func WithWriteThrough() Option {
+	return func(o *Options) {
+		o.writeThrough = true
+	}
+}
+
+// 开启缓存 miss 合并机制. 同一进程内相同 key 并发发生缓存 miss 时，只有一个调用方读 db 并写缓存，其余调用方等待并共享其结果
+func WithMissCoalescing() Option {
+	return func(o *Options) {
+		o.missCoalescing = true
+	}
+}
+
+// 开启分布式缓存 miss 锁. 跨进程范围内相同 key 发生缓存 miss 时，只有抢到锁的调用方读 db 并写缓存
+// 未抢到锁的调用方默认直接读 db，可以通过 WithMissLockPoll 改为先轮询缓存
+func WithMissLock(lockExpireMilis int64) Option {
+	return func(o *Options) {
+		o.missLockExpireMilis = lockExpireMilis
+	}
+}
+
+// 未抢到缓存 miss 锁时，每隔 pollMilis 轮询一次缓存，最长等待 waitMilis. 等待超时后直接读 db
+func WithMissLockPoll(waitMilis, pollMilis int64) Option {
+	return func(o *Options) {
+		o.missLockWaitMilis = waitMilis
+		o.missLockPollMilis = pollMilis
+	}
+}
+
+// 设置版本号墓碑的过期时间，需要大于读流程中一次读 db 的最长耗时. 默认与缓存过期时间一致
+func WithVersionTombstoneExpireSeconds(versionTombstoneExpireSeconds int64) Option {
+	return func(o *Options) {
+		o.versionTombstoneExpireSeconds = versionTombstoneExpireSeconds
+	}
+}
+
+// 开启租约模式，作为 disable/enable 机制的替代方案. 参考 memcache 的 lease 机制：
+// 读流程缓存 miss 时获取 key 的租约，只有仍持有有效租约时才能写缓存；写流程先写 db 再删除缓存，删除缓存时撤销 key 的全部租约
+// 同一时刻一个 key 只会授予一份租约，未获得租约的调用方在 waitMilis 内轮询缓存，超时后直接读 db 且不写缓存
+// 租约模式下 WithMissLock 不生效
+func WithLeaseMode(leaseMilis, waitMilis int64) Option {
+	return func(o *Options) {
+		o.leaseMilis = leaseMilis
+		o.leaseWaitMilis = waitMilis
+	}
+}
+
+// 将写流程的延时 enable 操作持久化到延时任务队列中，由每个 Service 实例上的 worker 领取并执行，执行失败时退避重试
+// 避免进程崩溃或者缓存模块短暂不可用导致 enable 操作丢失，key 直到 disable 过期前都无法写缓存
+// 开启后，使用完毕需要调用 Service.Close 方法
+func WithDelayQueue(queue DelayQueue) Option {
+	return func(o *Options) {
+		o.delayQueue = queue
+	}
+}
+
+// 开启 stale-while-revalidate. 缓存数据在 CacheExpireSeconds 后软过期，在此之后的 staleSeconds 内仍保留在缓存中
+// 读操作读取到软过期的数据时直接返回，并在后台刷新数据，后台刷新同样受读流程写缓存机制的约束
+// 处于禁用状态的 key 不会返回过期数据. 租约模式下不生效
+func WithStaleWhileRevalidate(staleSeconds int64) Option {
+	return func(o *Options) {
+		o.staleSeconds = staleSeconds
+	}
+}
+
+// 开启概率性提前刷新（XFetch 算法）. 读操作命中缓存时，根据缓存剩余过期时间与观测到的加载耗时，以一定概率提前加载数据并写缓存
+// 剩余过期时间越短、加载耗时越长，提前刷新的概率越高，从而避免热点 key 过期瞬间大量请求同时 miss
+// beta 为激进程度，取 1 即为 XFetch 算法的推荐值，越大越倾向于提前刷新. 租约模式下不生效
+func WithEarlyRefresh(beta float64) Option {
+	return func(o *Options) {
+		o.earlyRefreshBeta = beta
+	}
+}
+
+// 开启 fail-static 模式. 读流程写缓存时额外写入一份过期时间为 shadowExpireSeconds 的影子副本
+// 加载数据失败（非数据不存在类错误，例如 db 不可用）时，读操作返回影子副本，并通过 GetInfo.Degraded 标识
+// 写流程删除缓存时同时删除影子副本. 批量读操作不维护影子副本
+func WithFailStatic(shadowExpireSeconds int64) Option {
+	return func(o *Options) {
+		o.shadowExpireSeconds = shadowExpireSeconds
+	}
+}
+
+// 设置重试策略. 分别作用于写流程的每个步骤（禁用、删除缓存、写 db、写穿缓存）、读流程的读缓存与写缓存，以及后台的延时启用操作
+// 重试会遵循调用方 ctx 的截止时间. 开启后写 db 操作可能被重复执行，需要保证幂等
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *Options) {
+		o.retryPolicy = policy
+	}
+}
+
+// 开启写回模式. Put 操作将变更追加到持久化的缓冲区 buffer 后立即写缓存，由后台协程按 key 合并变更后批量写入 db
+// newObject 用于构造空的 Object，再通过 Object.Read 读取缓冲区中的变更，不能为空
+// 缓冲区已满时 Put 返回 ErrorWriteBufferFull. Del、MPut 操作仍同步写 db，写 db 之前先将缓冲区中的变更全部写入 db，保证与先前 Put 操作之间的先后顺序
+// 写回模式下缓存的版本号取自缓冲区的追加序号，与 Versioned 接口的版本号相互冲突，newObject 构造的 Object 实现了 Versioned 接口时会 panic
+// 开启后，使用完毕需要调用 Service.Close 方法
+func WithWriteBehind(buffer WriteBuffer, newObject func() Object) Option {
+	return func(o *Options) {
+		o.writeBuffer = buffer
+		o.newObject = newObject
+	}
+}
+
+// 添加读操作拦截器，作用于 Get、GetWithInfo、GetWithResult、GetOrLoad 以及 MGet 中的每笔数据. 多次调用时追加，先添加的拦截器位于外层
+func WithGetInterceptors(interceptors ...GetInterceptor) Option {
+	return func(o *Options) {
+		o.getInterceptors = append(o.getInterceptors, interceptors...)
+	}
+}
+
+// 添加写操作拦截器，作用于 Put、Del、Invalidate 以及 MPut 中的每笔数据，通过 WriteKindFromContext 区分写操作的类型. 多次调用时追加，先添加的拦截器位于外层
+func WithPutInterceptors(interceptors ...PutInterceptor) Option {
+	return func(o *Options) {
+		o.putInterceptors = append(o.putInterceptors, interceptors...)
+	}
+}
+
+// 设置监控指标上报模块，默认不上报
+func WithMetrics(metrics Metrics) Option {
+	return func(o *Options) {
+		o.metrics = metrics
+	}
+}
+
+// 开启链路追踪. 为读写操作、写流程的每个步骤以及后台的延时启用操作创建 span，延时启用操作的 span 链接到对应写流程的 span
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(o *Options) {
+		o.tracerProvider = provider
+	}
+}
+
 func WithLogger(logger Logger) Option {
 	return func(o *Options) {
 		o.logger = logger
@@ -86,7 +307,188 @@ func repair(o *Options) {
 		o.enableDelayMilis = DefaultEnableDelayMilis
 	}
 
+	if o.doubleDeleteDelayMilis <= 0 {
+		o.doubleDeleteDelayMilis = DefaultDoubleDeleteDelayMilis
+	}
+
+	if o.missLockWaitMilis > 0 && o.missLockPollMilis <= 0 {
+		o.missLockPollMilis = DefaultMissLockPollMilis
+	}
+
+	if o.versionTombstoneExpireSeconds <= 0 {
+		o.versionTombstoneExpireSeconds = o.cacheExpireSeconds
+	}
+
+	if o.writeBuffer != nil {
+		if o.newObject == nil {
+			panic("write behind mode requires newObject to decode buffered entries")
+		}
+		if _, ok := o.newObject().(Versioned); ok {
+			panic("write behind mode uses buffer seq as cache version, object must not implement Versioned")
+		}
+	}
+
+	if o.leaseMilis > 0 {
+		o.staleSeconds = 0
+		o.earlyRefreshBeta = 0
+	}
+
+	if o.metrics == nil {
+		o.metrics = noopMetrics{}
+	}
+
+	if o.tracerProvider == nil {
+		o.tracerProvider = trace.NewNoopTracerProvider()
+	}
+	o.tracer = o.tracerProvider.Tracer(tracerName)
+
 	if o.logger == nil {
 		o.logger = log.GetLogger()
 	}
 }
+
+// 缓存模块单次调用的配置项
+type CacheOptions struct {
+	// 数据的版本号. 大于 0 时开启版本校验
+	Version int64
+	// 批量操作中与 keys 一一对应的版本号
+	Versions []int64
+	// 版本号墓碑的过期时间，单位：秒
+	TombstoneExpireSeconds int64
+	// 租约 token. 非空时只有仍持有 key 的租约才能写缓存
+	LeaseToken string
+	// 写流程 token. 非空时由写流程写缓存，忽略自身的禁用，只有在没有其他写流程并发时才能写缓存
+	WriterToken string
+	// 影子副本的过期时间，单位：秒. 大于 0 时写缓存成功后额外写入一份影子副本
+	ShadowExpireSeconds int64
+	// 写入的是否为 NullData. 为 true 时，缓存中存在版本号或版本号墓碑则拒绝写入
+	NullData bool
+}
+
+type CacheOption func(*CacheOptions)
+
+// 基于 opts 构造缓存模块单次调用的配置项，供缓存模块的实现方使用
+func NewCacheOptions(opts ...CacheOption) *CacheOptions {
+	o := CacheOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &o
+}
+
+// 写缓存时校验版本号，拒绝写入低于已有版本号的数据；删除缓存时留下版本号墓碑
+func WithVersion(version int64) CacheOption {
+	return func(o *CacheOptions) {
+		o.Version = version
+	}
+}
+
+// 批量版本的 WithVersion，versions 与 keys 一一对应，版本号为 0 表示不进行版本校验
+func WithVersions(versions []int64) CacheOption {
+	return func(o *CacheOptions) {
+		o.Versions = versions
+	}
+}
+
+// 删除缓存时，版本号墓碑的过期时间
+func WithTombstoneExpireSeconds(expireSeconds int64) CacheOption {
+	return func(o *CacheOptions) {
+		o.TombstoneExpireSeconds = expireSeconds
+	}
+}
+
+// 写缓存时校验调用方仍持有 key 的租约
+func WithLeaseToken(token string) CacheOption {
+	return func(o *CacheOptions) {
+		o.LeaseToken = token
+	}
+}
+
+// 以写流程 token 的身份写缓存，用于写穿模式
+// 要求 token 仍处于禁用期内，且不存在其他处于禁用期内的写流程，从而保证不会有更新的写操作与当前写操作并发
+func WithWriterToken(token string) CacheOption {
+	return func(o *CacheOptions) {
+		o.WriterToken = token
+	}
+}
+
+// 写入的数据为 NullData. 缓存中存在版本号或版本号墓碑时，说明数据已经被写入过，拒绝写入 NullData
+func WithNullData() CacheOption {
+	return func(o *CacheOptions) {
+		o.NullData = true
+	}
+}
+
+// 写缓存成功后额外写入一份过期时间更长的影子副本，供 fail-static 模式使用
+func WithShadowExpireSeconds(expireSeconds int64) CacheOption {
+	return func(o *CacheOptions) {
+		o.ShadowExpireSeconds = expireSeconds
+	}
+}
+
+// 读操作单次调用的配置项. 通过 ctx 传递给缓存模块与数据库模块，实现方可以通过 GetOptionsFromContext 获取
+// 例如 l1.Cache 在 BypassCache、ForceRefresh 时跳过本地缓存，并在 ForceRefresh 写缓存后使本地缓存失效
+// BypassCache 与 ForceRefresh 优先于 CacheOnly
+type GetOptions struct {
+	// 跳过缓存直接读 db，且不写缓存
+	BypassCache bool
+	// 跳过缓存直接读 db，并在读流程写缓存机制启用时写缓存
+	ForceRefresh bool
+	// 只读缓存，缓存 miss 时返回 ErrorCacheMiss 而不读 db
+	CacheOnly bool
+	// 不使用负缓存：缓存中的 NullData 视为缓存 miss，db 中不存在的数据也不写入 NullData
+	NoNegativeCache bool
+}
+
+type GetOption func(*GetOptions)
+
+type getOptionsKey struct{}
+
+// 将读操作的配置项注入 ctx 中
+func withGetOptions(ctx context.Context, opts []GetOption) context.Context {
+	if len(opts) == 0 {
+		return ctx
+	}
+	o := *GetOptionsFromContext(ctx)
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return context.WithValue(ctx, getOptionsKey{}, &o)
+}
+
+// 获取 ctx 中读操作的配置项，供缓存模块与数据库模块的实现方使用. 未设置时返回默认配置
+func GetOptionsFromContext(ctx context.Context) *GetOptions {
+	if o, ok := ctx.Value(getOptionsKey{}).(*GetOptions); ok {
+		return o
+	}
+	return &GetOptions{}
+}
+
+// 跳过缓存直接读 db，且不写缓存. 适用于管理后台等需要读取 db 中最新数据的场景
+func BypassCache() GetOption {
+	return func(o *GetOptions) {
+		o.BypassCache = true
+	}
+}
+
+// 跳过缓存直接读 db，并在读流程写缓存机制启用时写缓存. 租约模式下由于未持有租约，不写缓存
+func ForceRefresh() GetOption {
+	return func(o *GetOptions) {
+		o.ForceRefresh = true
+	}
+}
+
+// 只读缓存，缓存 miss 时返回 ErrorCacheMiss，缓存不可用时返回 ErrorCacheUnavailable. 适用于对时延敏感、不允许访问 db 的场景
+// 软过期的数据只在 key 处于启用状态时返回，不触发后台刷新；key 处于禁用状态时视为缓存 miss
+func CacheOnly() GetOption {
+	return func(o *GetOptions) {
+		o.CacheOnly = true
+	}
+}
+
+// 不使用负缓存. 缓存中的 NullData 视为缓存 miss，db 中不存在的数据也不写入 NullData
+func NoNegativeCache() GetOption {
+	return func(o *GetOptions) {
+		o.NoNegativeCache = true
+	}
+}
@@ -0,0 +1,105 @@
+package prometheus
+
+import (
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+
+	"github.com/xiaoxuxiansheng/consistent_cache"
+)
+
+// 结果标签的取值
+const (
+	resultAccepted = "accepted"
+	resultRejected = "rejected"
+	resultSuccess  = "success"
+	resultFailure  = "failure"
+)
+
+// prometheus 实现版本的监控指标上报模块
+type Metrics struct {
+	// 读操作次数，按结果分类
+	gets *prom.CounterVec
+	// 读流程写缓存次数，按是否被接受分类
+	fills *prom.CounterVec
+	// 写流程各步骤的耗时
+	putSteps *prom.HistogramVec
+	// 延时启用操作的耗时
+	enables *prom.HistogramVec
+	// 延时启用操作失败的次数
+	enableFailures prom.Counter
+}
+
+var _ consistent_cache.Metrics = (*Metrics)(nil)
+
+// 构造器函数. 指标注册失败（例如重复注册）时会 panic
+func NewMetrics(opts ...Option) *Metrics {
+	o := Options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	repair(&o)
+
+	m := Metrics{
+		gets: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "get_total",
+			Help:      "Number of get operations by result.",
+		}, []string{"result"}),
+		fills: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "fill_total",
+			Help:      "Number of cache fills by the read path, partitioned by whether the fill was accepted.",
+		}, []string{"result"}),
+		putSteps: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: o.namespace,
+			Name:      "put_step_duration_seconds",
+			Help:      "Latency of each step of the write path.",
+			Buckets:   o.buckets,
+		}, []string{"step", "result"}),
+		enables: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: o.namespace,
+			Name:      "enable_duration_seconds",
+			Help:      "Latency of the delayed enable operations.",
+			Buckets:   o.buckets,
+		}, []string{"result"}),
+		enableFailures: prom.NewCounter(prom.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "enable_failures_total",
+			Help:      "Number of failed delayed enable operations.",
+		}),
+	}
+
+	o.registerer.MustRegister(m.gets, m.fills, m.putSteps, m.enables, m.enableFailures)
+	return &m
+}
+
+func (m *Metrics) ObserveGet(result string) {
+	m.gets.WithLabelValues(result).Inc()
+}
+
+func (m *Metrics) ObserveFill(accepted bool) {
+	result := resultRejected
+	if accepted {
+		result = resultAccepted
+	}
+	m.fills.WithLabelValues(result).Inc()
+}
+
+func (m *Metrics) ObservePutStep(step string, cost time.Duration, err error) {
+	m.putSteps.WithLabelValues(step, errResult(err)).Observe(cost.Seconds())
+}
+
+func (m *Metrics) ObserveEnable(cost time.Duration, err error) {
+	m.enables.WithLabelValues(errResult(err)).Observe(cost.Seconds())
+	if err != nil {
+		m.enableFailures.Inc()
+	}
+}
+
+func errResult(err error) string {
+	if err != nil {
+		return resultFailure
+	}
+	return resultSuccess
+}
@@ -0,0 +1,52 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xiaoxuxiansheng/consistent_cache"
+)
+
+// 验证点：上报的指标可以通过本地 http handler 抓取
+func Test_Metrics(t *testing.T) {
+	registry := prom.NewRegistry()
+	metrics := NewMetrics(WithRegisterer(registry))
+
+	metrics.ObserveGet(consistent_cache.GetResultHit)
+	metrics.ObserveGet(consistent_cache.GetResultHit)
+	metrics.ObserveGet(consistent_cache.GetResultDBMiss)
+	metrics.ObserveFill(true)
+	metrics.ObserveFill(false)
+	metrics.ObservePutStep(consistent_cache.PutStepDisable, 0, nil)
+	metrics.ObserveEnable(0, errors.New("i/o timeout"))
+
+	server := httptest.NewServer(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	defer server.Close()
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+	assert.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	for _, line := range []string{
+		`consistent_cache_get_total{result="hit"} 2`,
+		`consistent_cache_get_total{result="db_miss"} 1`,
+		`consistent_cache_fill_total{result="accepted"} 1`,
+		`consistent_cache_fill_total{result="rejected"} 1`,
+		`consistent_cache_put_step_duration_seconds_count{result="success",step="disable"} 1`,
+		`consistent_cache_enable_duration_seconds_count{result="failure"} 1`,
+		`consistent_cache_enable_failures_total 1`,
+	} {
+		assert.Contains(t, string(body), line)
+	}
+}
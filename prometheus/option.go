@@ -0,0 +1,55 @@
+package prometheus
+
+import (
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+type Options struct {
+	// 指标名称的命名空间
+	namespace string
+	// 指标注册器
+	registerer prom.Registerer
+	// 耗时类指标的分桶，单位：秒
+	buckets []float64
+}
+
+type Option func(*Options)
+
+// 默认的指标命名空间
+const DefaultNamespace = "consistent_cache"
+
+// 默认的耗时分桶，覆盖 0.5 ms 到 2.5 s
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+func WithNamespace(namespace string) Option {
+	return func(o *Options) {
+		o.namespace = namespace
+	}
+}
+
+// 指定指标注册器，默认注册到 prometheus 全局的 DefaultRegisterer
+func WithRegisterer(registerer prom.Registerer) Option {
+	return func(o *Options) {
+		o.registerer = registerer
+	}
+}
+
+func WithBuckets(buckets []float64) Option {
+	return func(o *Options) {
+		o.buckets = buckets
+	}
+}
+
+func repair(o *Options) {
+	if o.namespace == "" {
+		o.namespace = DefaultNamespace
+	}
+
+	if o.registerer == nil {
+		o.registerer = prom.DefaultRegisterer
+	}
+
+	if len(o.buckets) == 0 {
+		o.buckets = DefaultBuckets
+	}
+}
@@ -0,0 +1,29 @@
+package redis
+
+import (
+	"context"
+)
+
+// 基于 redis pub/sub 实现的消息广播模块
+type Broker struct {
+	client  *RClient
+	channel string
+}
+
+// 构造器函数. 所有订阅相同 channel 的进程都会接收到广播的消息
+func NewBroker(config *Config, channel string) *Broker {
+	return &Broker{
+		client:  NewRClient(config),
+		channel: channel,
+	}
+}
+
+// 广播消息
+func (b *Broker) Publish(ctx context.Context, message string) error {
+	return b.client.Publish(ctx, b.channel, message)
+}
+
+// 订阅消息. 会阻塞直到 ctx 终止或者连接出错
+func (b *Broker) Subscribe(ctx context.Context, handler func(message string)) error {
+	return b.client.Subscribe(ctx, b.channel, handler)
+}
@@ -15,8 +15,8 @@ type Client interface {
 	Eval(ctx context.Context, src string, keyCount int, keysAndArgs []interface{}) (interface{}, error)
 	Get(ctx context.Context, key string) (string, error)
 	SetEx(ctx context.Context, key, value string, expireSeconds int64) error
-	Del(ctx context.Context, key string) error
-	PExpire(ctx context.Context, key string, expireMilis int64) error
+	Del(ctx context.Context, keys ...string) error
+	MGet(ctx context.Context, keys []string) ([]interface{}, error)
 }
 
 // redis 实现版本的缓存模块
@@ -29,17 +29,29 @@ func NewRedisCache(config *Config) *Cache {
 	return &Cache{client: NewRClient(config)}
 }
 
-// 启用某个 key 对应读流程写缓存机制（默认情况下为启用状态）
-func (c *Cache) Enable(ctx context.Context, key string, delayMilis int64) error {
-	// redis 中删除 key 对应的 disable key. 只要 disable key 标识不存在，则读流程写缓存机制视为启用状态
-	// 给 disable key 设置一个相对较短的过期时间
-	return c.client.PExpire(ctx, key, delayMilis)
+// 撤销写流程 token 对 key 的禁用（默认情况下为启用状态）
+func (c *Cache) Enable(ctx context.Context, key, token string, delayMilis int64) error {
+	// 将 disable key 中 token 对应的截止时间提前到 delayMilis 之后
+	// 其他写流程的截止时间不受影响，因此只有最后一个写流程的延时结束后，读流程写缓存机制才会启用
+	_, err := c.client.Eval(ctx, LuaEnableCache, 1, []interface{}{
+		c.disableKey(key),
+		token,
+		delayMilis,
+	})
+	return err
 }
 
-// 禁用某个 key 的读流程写缓存机制
-func (c *Cache) Disable(ctx context.Context, key string, expireSeconds int64) error {
-	// redis 中设置 key 对应的 disable key. 只要 disable key 标识存在，则读流程写缓存机制视为禁用状态
-	return c.client.SetEx(ctx, c.disableKey(key), "1", expireSeconds)
+// 以写流程 token 的身份禁用某个 key 的读流程写缓存机制
+func (c *Cache) Disable(ctx context.Context, key, token string, expireSeconds int64) error {
+	// redis 中 disable key 为 hash 结构，记录每个写流程 token 的禁用截止时间. 只要存在一个截止时间未到的 token，则读流程写缓存机制视为禁用状态
+	// 同时撤销 key 尚未使用的租约
+	_, err := c.client.Eval(ctx, LuaDisableCache, 2, []interface{}{
+		c.disableKey(key),
+		c.leaseKey(key),
+		token,
+		expireSeconds,
+	})
+	return err
 }
 
 // 读取 key 对应缓存内容
@@ -55,9 +67,82 @@ func (c *Cache) Get(ctx context.Context, key string) (string, error) {
 	return reply, nil
 }
 
+// 读取 key 对应的影子副本
+func (c *Cache) GetShadow(ctx context.Context, key string) (string, error) {
+	reply, err := c.client.Get(ctx, c.shadowKey(key))
+	if errors.Is(err, redis.ErrNil) {
+		return "", consistent_cache.ErrorCacheMiss
+	}
+	return reply, err
+}
+
+// 读取 key 对应缓存内容以及剩余过期时间，单位：毫秒. key 未设置过期时间时返回 -1
+func (c *Cache) GetWithTTL(ctx context.Context, key string) (string, int64, error) {
+	reply, err := c.client.Eval(ctx, LuaGetWithTTL, 1, []interface{}{
+		key,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	replies, err := redis.Values(reply, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(replies) == 0 || cast.ToInt(replies[0]) != 1 {
+		return "", 0, consistent_cache.ErrorCacheMiss
+	}
+	if len(replies) != 3 {
+		return "", 0, fmt.Errorf("invalid eval reply len: %d, expect: 3", len(replies))
+	}
+	value, err := redis.String(replies[1], nil)
+	if err != nil {
+		return "", 0, err
+	}
+	return value, cast.ToInt64(replies[2]), nil
+}
+
+// 查询某个 key 对应读流程写缓存机制是否启用
+func (c *Cache) IsEnabled(ctx context.Context, key string) (bool, error) {
+	reply, err := c.client.Eval(ctx, LuaCheckEnable, 1, []interface{}{
+		c.disableKey(key),
+	})
+	if err != nil {
+		return false, err
+	}
+	return cast.ToInt(reply) == 1, nil
+}
+
 // 校验某个 key 对应读流程写缓存机制是否启用，倘若启用则写入缓存（默认情况下为启用状态）
-func (c *Cache) PutWhenEnable(ctx context.Context, key, value string, expireSeconds int64) (bool, error) {
-	// 运行 redis lua 脚本，保证只有在 disable key 不存在时，才会执行 key 的写入
+// 通过 WithShadowExpireSeconds 在写入成功后额外写入影子副本，影子副本写入失败时返回 true 以及对应错误
+func (c *Cache) PutWhenEnable(ctx context.Context, key, value string, expireSeconds int64, opts ...consistent_cache.CacheOption) (bool, error) {
+	o := consistent_cache.NewCacheOptions(opts...)
+	ok, err := c.putWhenEnable(ctx, key, value, expireSeconds, o)
+	if err != nil || !ok || o.ShadowExpireSeconds <= 0 {
+		return ok, err
+	}
+
+	// 运行 redis lua 脚本，只有 key 的当前值仍为 value 时才写入影子副本，避免覆盖写缓存之后的并发删除
+	_, err = c.client.Eval(ctx, LuaSetShadowIfCurrent, 2, []interface{}{
+		key,
+		c.shadowKey(key),
+		value,
+		o.ShadowExpireSeconds,
+	})
+	return true, err
+}
+
+func (c *Cache) putWhenEnable(ctx context.Context, key, value string, expireSeconds int64, o *consistent_cache.CacheOptions) (bool, error) {
+	if o.WriterToken != "" {
+		return c.putWhenWriter(ctx, key, value, expireSeconds, o.WriterToken, o.Version)
+	}
+	if o.LeaseToken != "" {
+		return c.putWhenEnableAndLease(ctx, key, value, expireSeconds, o.LeaseToken, scriptVersion(o.Version, o.NullData))
+	}
+	if o.Version > 0 || o.NullData {
+		return c.putWhenEnableAndVersion(ctx, key, value, expireSeconds, scriptVersion(o.Version, o.NullData))
+	}
+
+	// 运行 redis lua 脚本，保证只有在全部写流程的禁用都已过期时，才会执行 key 的写入
 	reply, err := c.client.Eval(ctx, LuaCheckEnableAndWriteCache, 2, []interface{}{
 		c.disableKey(key),
 		key,
@@ -70,10 +155,270 @@ func (c *Cache) PutWhenEnable(ctx context.Context, key, value string, expireSeco
 	return cast.ToInt(reply) == 1, nil
 }
 
+// 直接写入缓存，不校验读流程写缓存机制是否启用. 通过 WithVersion 校验版本号不低于缓存中已有的版本号
+// 写入成功时删除过时的影子副本
+func (c *Cache) Set(ctx context.Context, key, value string, expireSeconds int64, opts ...consistent_cache.CacheOption) (bool, error) {
+	o := consistent_cache.NewCacheOptions(opts...)
+	if o.Version <= 0 {
+		if err := c.client.SetEx(ctx, key, value, expireSeconds); err != nil {
+			return false, err
+		}
+		return true, c.client.Del(ctx, c.shadowKey(key))
+	}
+
+	reply, err := c.client.Eval(ctx, LuaCheckVersionAndSetCache, 3, []interface{}{
+		key,
+		c.versionKey(key),
+		c.shadowKey(key),
+		value,
+		expireSeconds,
+		o.Version,
+	})
+	if err != nil {
+		return false, err
+	}
+	return cast.ToInt(reply) == 1, nil
+}
+
+// 在 PutWhenEnable 的基础上，额外校验版本号不低于缓存中已有的版本号
+func (c *Cache) putWhenEnableAndVersion(ctx context.Context, key, value string, expireSeconds, version int64) (bool, error) {
+	reply, err := c.client.Eval(ctx, LuaCheckEnableAndVersionAndWriteCache, 3, []interface{}{
+		c.disableKey(key),
+		key,
+		c.versionKey(key),
+		value,
+		expireSeconds,
+		version,
+	})
+	if err != nil {
+		return false, err
+	}
+	return cast.ToInt(reply) == 1, nil
+}
+
+// 传递给 lua 脚本的版本号. 写入 NullData 时为 -1，表示存在版本号或版本号墓碑时拒绝写入
+func scriptVersion(version int64, nullData bool) int64 {
+	if nullData {
+		return -1
+	}
+	return version
+}
+
+// 在 PutWhenEnable 的基础上，额外校验调用方仍持有 key 的租约
+func (c *Cache) putWhenEnableAndLease(ctx context.Context, key, value string, expireSeconds int64, token string, version int64) (bool, error) {
+	reply, err := c.client.Eval(ctx, LuaCheckLeaseAndWriteCache, 4, []interface{}{
+		c.disableKey(key),
+		key,
+		c.leaseKey(key),
+		c.versionKey(key),
+		value,
+		expireSeconds,
+		token,
+		version,
+	})
+	if err != nil {
+		return false, err
+	}
+	return cast.ToInt(reply) == 1, nil
+}
+
+// 以写流程 token 的身份写缓存. 只有 token 仍处于禁用期内，且不存在其他处于禁用期内的写流程时才会写入
+func (c *Cache) putWhenWriter(ctx context.Context, key, value string, expireSeconds int64, token string, version int64) (bool, error) {
+	reply, err := c.client.Eval(ctx, LuaCheckWriterAndWriteCache, 3, []interface{}{
+		c.disableKey(key),
+		key,
+		c.versionKey(key),
+		value,
+		expireSeconds,
+		token,
+		version,
+	})
+	if err != nil {
+		return false, err
+	}
+	return cast.ToInt(reply) == 1, nil
+}
+
+// 读取 key 对应缓存内容. 缓存 miss 时尝试为调用方授予租约，租约的有效期为 leaseMilis
+func (c *Cache) GetWithLease(ctx context.Context, key, token string, leaseMilis int64) (string, bool, error) {
+	reply, err := c.client.Eval(ctx, LuaGetOrLease, 2, []interface{}{
+		key,
+		c.leaseKey(key),
+		token,
+		leaseMilis,
+	})
+	if err != nil {
+		return "", false, err
+	}
+	replies, err := redis.Values(reply, nil)
+	if err != nil {
+		return "", false, err
+	}
+	if len(replies) != 2 {
+		return "", false, fmt.Errorf("invalid eval reply len: %d, expect: 2", len(replies))
+	}
+
+	// 命中缓存
+	if cast.ToInt(replies[0]) == 1 {
+		value, err := redis.String(replies[1], nil)
+		return value, false, err
+	}
+	// 缓存 miss，返回是否获得租约
+	return "", cast.ToInt(replies[1]) == 1, consistent_cache.ErrorCacheMiss
+}
+
+// 批量读取 keys 对应缓存内容，返回结果中只包含命中缓存的 key
+func (c *Cache) MGet(ctx context.Context, keys []string) (map[string]string, error) {
+	if len(keys) == 0 {
+		return map[string]string{}, nil
+	}
+
+	// 通过一次 MGET 指令读取全部 kv 对，未命中的 key 对应结果为 nil
+	replies, err := c.client.MGet(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	if len(replies) != len(keys) {
+		return nil, fmt.Errorf("invalid mget reply len: %d, expect: %d", len(replies), len(keys))
+	}
+
+	values := make(map[string]string, len(keys))
+	for i, reply := range replies {
+		if reply == nil {
+			continue
+		}
+		value, err := redis.String(reply, nil)
+		if err != nil {
+			return nil, err
+		}
+		values[keys[i]] = value
+	}
+	return values, nil
+}
+
+// 批量校验 key 对应读流程写缓存机制是否启用，倘若启用则写入缓存
+func (c *Cache) MPutWhenEnable(ctx context.Context, entries []consistent_cache.CacheEntry) ([]bool, error) {
+	if len(entries) == 0 {
+		return []bool{}, nil
+	}
+
+	// 通过一次 lua 脚本调用完成全部 key 的校验和写入
+	// 注意：在 redis 集群模式下，要求全部 key 被分发到相同节点
+	keysAndArgs := make([]interface{}, 0, 6*len(entries))
+	for _, entry := range entries {
+		keysAndArgs = append(keysAndArgs, c.disableKey(entry.Key), entry.Key, c.versionKey(entry.Key))
+	}
+	for _, entry := range entries {
+		keysAndArgs = append(keysAndArgs, entry.Value, entry.ExpireSeconds, scriptVersion(entry.Version, entry.NullData))
+	}
+
+	reply, err := c.client.Eval(ctx, LuaBatchCheckEnableAndWriteCache, 3*len(entries), keysAndArgs)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := redis.Ints(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(replies) != len(entries) {
+		return nil, fmt.Errorf("invalid eval reply len: %d, expect: %d", len(replies), len(entries))
+	}
+
+	oks := make([]bool, len(entries))
+	for i, reply := range replies {
+		oks[i] = reply == 1
+	}
+	return oks, nil
+}
+
+// 批量禁用 keys 的读流程写缓存机制，并删除 keys 对应缓存
+func (c *Cache) MDisableAndDel(ctx context.Context, keys []string, token string, expireSeconds int64, opts ...consistent_cache.CacheOption) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	o := consistent_cache.NewCacheOptions(opts...)
+	if len(o.Versions) > 0 && len(o.Versions) != len(keys) {
+		return fmt.Errorf("invalid versions len: %d, expect: %d", len(o.Versions), len(keys))
+	}
+
+	// 通过一次 lua 脚本调用完成全部 key 的 disable 和删除操作
+	// 注意：在 redis 集群模式下，要求全部 key 被分发到相同节点
+	keysAndArgs := make([]interface{}, 0, 6*len(keys)+3)
+	for _, key := range keys {
+		keysAndArgs = append(keysAndArgs, c.disableKey(key), key, c.versionKey(key), c.leaseKey(key), c.shadowKey(key))
+	}
+	keysAndArgs = append(keysAndArgs, token, expireSeconds, o.TombstoneExpireSeconds)
+	for i := range keys {
+		var version int64
+		if len(o.Versions) > 0 {
+			version = o.Versions[i]
+		}
+		keysAndArgs = append(keysAndArgs, version)
+	}
+
+	_, err := c.client.Eval(ctx, LuaBatchDisableAndDeleteCache, 5*len(keys), keysAndArgs)
+	return err
+}
+
+// 批量撤销写流程 token 对 keys 的禁用
+func (c *Cache) MEnable(ctx context.Context, keys []string, token string, delayMilis int64) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	keysAndArgs := make([]interface{}, 0, len(keys)+2)
+	for _, key := range keys {
+		keysAndArgs = append(keysAndArgs, c.disableKey(key))
+	}
+	keysAndArgs = append(keysAndArgs, token, delayMilis)
+
+	_, err := c.client.Eval(ctx, LuaBatchEnableCache, len(keys), keysAndArgs)
+	return err
+}
+
+// 尝试获取 key 对应的缓存 miss 锁
+func (c *Cache) Lock(ctx context.Context, key, token string, expireMilis int64) (bool, error) {
+	// 运行 redis lua 脚本，锁未被持有时抢锁，已由 token 持有时续期. 锁的 value 为持有者 token
+	reply, err := c.client.Eval(ctx, LuaLockOrRenew, 1, []interface{}{
+		c.lockKey(key),
+		token,
+		expireMilis,
+	})
+	if err != nil {
+		return false, err
+	}
+	return cast.ToInt(reply) == 1, nil
+}
+
+// 释放 key 对应的缓存 miss 锁
+func (c *Cache) Unlock(ctx context.Context, key, token string) error {
+	// 运行 redis lua 脚本，保证只有锁的持有者才能解锁
+	_, err := c.client.Eval(ctx, LuaCheckTokenAndUnlock, 1, []interface{}{
+		c.lockKey(key),
+		token,
+	})
+	return err
+}
+
 // 删除 key 对应缓存
-func (c *Cache) Del(ctx context.Context, key string) error {
-	// 从 reids 中删除 kv 对
-	return c.client.Del(ctx, key)
+func (c *Cache) Del(ctx context.Context, key string, opts ...consistent_cache.CacheOption) error {
+	o := consistent_cache.NewCacheOptions(opts...)
+	if o.Version <= 0 {
+		// 从 reids 中删除 kv 对及其影子副本，同时撤销 key 尚未使用的租约
+		return c.client.Del(ctx, key, c.leaseKey(key), c.shadowKey(key))
+	}
+
+	// 运行 redis lua 脚本，删除 kv 对的同时留下版本号墓碑，拒绝后续低于该版本号的数据写入缓存
+	_, err := c.client.Eval(ctx, LuaDeleteCacheAndWriteTombstone, 4, []interface{}{
+		key,
+		c.versionKey(key),
+		c.leaseKey(key),
+		c.shadowKey(key),
+		o.Version,
+		o.TombstoneExpireSeconds,
+	})
+	return err
 }
 
 // 基于 key 映射得到 v key 表达式
@@ -81,3 +426,27 @@ func (c *Cache) disableKey(key string) string {
 	// 通过 {hash_tag}，保证在 redis 集群模式下，key 和 disable key 也会被分发到相同节点
 	return fmt.Sprintf("Enable_Lock_Key_{%s}", key)
 }
+
+// 基于 key 映射得到缓存 miss 锁的 key
+func (c *Cache) lockKey(key string) string {
+	// 与 disable key 相同，通过 {hash_tag} 保证在 redis 集群模式下与 key 分发到相同节点
+	return fmt.Sprintf("Miss_Lock_Key_{%s}", key)
+}
+
+// 基于 key 映射得到存储版本号的 key
+func (c *Cache) versionKey(key string) string {
+	// 与 disable key 相同，通过 {hash_tag} 保证在 redis 集群模式下与 key 分发到相同节点
+	return fmt.Sprintf("Version_Key_{%s}", key)
+}
+
+// 基于 key 映射得到存储租约的 key
+func (c *Cache) leaseKey(key string) string {
+	// 与 disable key 相同，通过 {hash_tag} 保证在 redis 集群模式下与 key 分发到相同节点
+	return fmt.Sprintf("Lease_Key_{%s}", key)
+}
+
+// 基于 key 映射得到存储影子副本的 key
+func (c *Cache) shadowKey(key string) string {
+	// 与 disable key 相同，通过 {hash_tag} 保证在 redis 集群模式下与 key 分发到相同节点
+	return fmt.Sprintf("Shadow_Key_{%s}", key)
+}
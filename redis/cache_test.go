@@ -0,0 +1,375 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/xiaoxuxiansheng/consistent_cache"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newCache(t *testing.T) (*Cache, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	return NewRedisCache(&Config{Address: mr.Addr()}), mr
+}
+
+// 验证点：disable 期间拒绝写缓存
+func Test_Cache_PutWhenEnable(t *testing.T) {
+	cache, _ := newCache(t)
+	ctx := context.Background()
+
+	ok, err := cache.PutWhenEnable(ctx, "key", "v1", 60)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.NoError(t, cache.Disable(ctx, "key", "writer", 10))
+	ok, err = cache.PutWhenEnable(ctx, "key", "v2", 60)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	v, err := cache.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", v)
+}
+
+// 验证点：同一 key 上存在两个重叠的写流程 A、B 时，A 先结束并延时启用，在 B 的写操作完成前，读流程仍不能写缓存
+// 只有最后一个写流程 B 的延时启用结束后，读流程才能写缓存
+func Test_Cache_OverlappingWriters(t *testing.T) {
+	cache, _ := newCache(t)
+	ctx := context.Background()
+
+	// 写流程 A、B 先后禁用 key
+	assert.NoError(t, cache.Disable(ctx, "key", "writerA", 10))
+	assert.NoError(t, cache.Disable(ctx, "key", "writerB", 10))
+
+	// 写流程 A 完成写 db，延时 50ms 启用
+	assert.NoError(t, cache.Enable(ctx, "key", "writerA", 50))
+	time.Sleep(100 * time.Millisecond)
+
+	// A 的延时已经结束，但 B 的写操作仍在进行中，读流程读到的 A 写入的数据不能写入缓存
+	ok, err := cache.PutWhenEnable(ctx, "key", "vA", 60)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	oks, err := cache.MPutWhenEnable(ctx, []consistent_cache.CacheEntry{{Key: "key", Value: "vA", ExpireSeconds: 60}})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{false}, oks)
+
+	// 写流程 B 完成写 db，延时 50ms 启用. 延时期间仍不能写缓存
+	assert.NoError(t, cache.Enable(ctx, "key", "writerB", 50))
+	ok, err = cache.PutWhenEnable(ctx, "key", "vA", 60)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// B 的延时结束后，读流程可以写缓存
+	time.Sleep(100 * time.Millisecond)
+	ok, err = cache.PutWhenEnable(ctx, "key", "vB", 60)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// 重复的 enable 操作不会延长禁用时间
+	assert.NoError(t, cache.Disable(ctx, "key", "writerC", 10))
+	assert.NoError(t, cache.Enable(ctx, "key", "writerC", 0))
+	assert.NoError(t, cache.Enable(ctx, "key", "writerC", 5000))
+	ok, err = cache.PutWhenEnable(ctx, "key", "vC", 60)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+// 验证点：1 写流程可以忽略自身的禁用写缓存 2 存在并发的写流程时拒绝写缓存 3 禁用期结束后拒绝写缓存
+func Test_Cache_WriteThrough(t *testing.T) {
+	cache, _ := newCache(t)
+	ctx := context.Background()
+
+	// 只有写流程 A 时，A 可以写缓存，读流程不能写缓存
+	assert.NoError(t, cache.Disable(ctx, "key", "writerA", 10))
+	ok, err := cache.PutWhenEnable(ctx, "key", "vA", 60, consistent_cache.WithWriterToken("writerA"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = cache.PutWhenEnable(ctx, "key", "v0", 60)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	v, err := cache.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "vA", v)
+
+	// 写流程 B 开始后，A、B 都不能写缓存
+	assert.NoError(t, cache.Disable(ctx, "key", "writerB", 10))
+	ok, err = cache.PutWhenEnable(ctx, "key", "vA", 60, consistent_cache.WithWriterToken("writerA"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = cache.PutWhenEnable(ctx, "key", "vB", 60, consistent_cache.WithWriterToken("writerB"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// A 的禁用期结束后，B 可以写缓存
+	assert.NoError(t, cache.Enable(ctx, "key", "writerA", 0))
+	ok, err = cache.PutWhenEnable(ctx, "key", "vB", 60, consistent_cache.WithWriterToken("writerB"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// B 的禁用期结束后，B 不能再以写流程的身份写缓存
+	assert.NoError(t, cache.Enable(ctx, "key", "writerB", 0))
+	ok, err = cache.PutWhenEnable(ctx, "key", "vB", 60, consistent_cache.WithWriterToken("writerB"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+// 验证点：1 拒绝写入低于已有版本号的数据 2 Del 留下的墓碑拒绝写入低于墓碑版本号的数据
+func Test_Cache_Version(t *testing.T) {
+	cache, _ := newCache(t)
+	ctx := context.Background()
+
+	ok, err := cache.PutWhenEnable(ctx, "key", "v2", 60, consistent_cache.WithVersion(2))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// 低版本数据拒绝写入
+	ok, err = cache.PutWhenEnable(ctx, "key", "v1", 60, consistent_cache.WithVersion(1))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// 相同版本数据允许写入
+	ok, err = cache.PutWhenEnable(ctx, "key", "v2", 60, consistent_cache.WithVersion(2))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// 删除缓存并留下版本号为 3 的墓碑
+	assert.NoError(t, cache.Del(ctx, "key", consistent_cache.WithVersion(3), consistent_cache.WithTombstoneExpireSeconds(60)))
+	_, err = cache.Get(ctx, "key")
+	assert.ErrorIs(t, err, consistent_cache.ErrorCacheMiss)
+
+	// 慢读流程读取到的旧数据拒绝写入
+	ok, err = cache.PutWhenEnable(ctx, "key", "v2", 60, consistent_cache.WithVersion(2))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// 存在版本号墓碑时，慢读流程读取到的数据不存在，拒绝写入 NullData
+	ok, err = cache.PutWhenEnable(ctx, "key", consistent_cache.NullData, 60, consistent_cache.WithNullData())
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = cache.PutWhenEnable(ctx, "absent", consistent_cache.NullData, 60, consistent_cache.WithNullData())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// 新数据允许写入
+	ok, err = cache.PutWhenEnable(ctx, "key", "v3", 60, consistent_cache.WithVersion(3))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// 批量写缓存同样进行版本校验
+	oks, err := cache.MPutWhenEnable(ctx, []consistent_cache.CacheEntry{
+		{Key: "key", Value: "v2", ExpireSeconds: 60, Version: 2},
+		{Key: "other", Value: "v1", ExpireSeconds: 60},
+		{Key: "key", Value: consistent_cache.NullData, ExpireSeconds: 60, NullData: true},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{false, true, false}, oks)
+
+	// 批量删除缓存同样留下墓碑
+	assert.NoError(t, cache.MDisableAndDel(ctx, []string{"key"}, "writer", 1,
+		consistent_cache.WithVersions([]int64{4}), consistent_cache.WithTombstoneExpireSeconds(60)))
+	assert.NoError(t, cache.MEnable(ctx, []string{"key"}, "writer", 0))
+	oks, err = cache.MPutWhenEnable(ctx, []consistent_cache.CacheEntry{
+		{Key: "key", Value: "v3", ExpireSeconds: 60, Version: 3},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{false}, oks)
+}
+
+// 验证点：1 同一时刻只授予一份租约 2 只有持有租约才能写缓存 3 Del 撤销租约
+func Test_Cache_Lease(t *testing.T) {
+	cache, _ := newCache(t)
+	ctx := context.Background()
+
+	// 第一个调用方获得租约
+	_, leased, err := cache.GetWithLease(ctx, "key", "token1", 1000)
+	assert.ErrorIs(t, err, consistent_cache.ErrorCacheMiss)
+	assert.True(t, leased)
+
+	// 第二个调用方未获得租约
+	_, leased, err = cache.GetWithLease(ctx, "key", "token2", 1000)
+	assert.ErrorIs(t, err, consistent_cache.ErrorCacheMiss)
+	assert.False(t, leased)
+
+	// 未持有租约的调用方不能写缓存
+	ok, err := cache.PutWhenEnable(ctx, "key", "v2", 60, consistent_cache.WithLeaseToken("token2"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// 持有租约的调用方写缓存成功
+	ok, err = cache.PutWhenEnable(ctx, "key", "v1", 60, consistent_cache.WithLeaseToken("token1"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	v, _, err := cache.GetWithLease(ctx, "key", "token3", 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", v)
+
+	// 写流程删除缓存后，重新授予租约，再次删除缓存时撤销租约
+	assert.NoError(t, cache.Del(ctx, "key"))
+	_, leased, err = cache.GetWithLease(ctx, "key", "token4", 1000)
+	assert.ErrorIs(t, err, consistent_cache.ErrorCacheMiss)
+	assert.True(t, leased)
+	assert.NoError(t, cache.Del(ctx, "key"))
+	ok, err = cache.PutWhenEnable(ctx, "key", "v0", 60, consistent_cache.WithLeaseToken("token4"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func Test_Cache_IsEnabled(t *testing.T) {
+	cache, _ := newCache(t)
+	ctx := context.Background()
+
+	enabled, err := cache.IsEnabled(ctx, "key")
+	assert.NoError(t, err)
+	assert.True(t, enabled)
+
+	assert.NoError(t, cache.Disable(ctx, "key", "writer", 10))
+	enabled, err = cache.IsEnabled(ctx, "key")
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+
+	assert.NoError(t, cache.Enable(ctx, "key", "writer", 0))
+	enabled, err = cache.IsEnabled(ctx, "key")
+	assert.NoError(t, err)
+	assert.True(t, enabled)
+}
+
+func Test_Cache_GetWithTTL(t *testing.T) {
+	cache, _ := newCache(t)
+	ctx := context.Background()
+
+	_, _, err := cache.GetWithTTL(ctx, "key")
+	assert.ErrorIs(t, err, consistent_cache.ErrorCacheMiss)
+
+	ok, err := cache.PutWhenEnable(ctx, "key", "v1", 60)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	v, ttlMilis, err := cache.GetWithTTL(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", v)
+	assert.Equal(t, int64(60000), ttlMilis)
+}
+
+// 验证点：1 写缓存时同时写入影子副本，影子副本过期时间更长 2 删除缓存以及直接写缓存时一并删除影子副本
+func Test_Cache_Shadow(t *testing.T) {
+	cache, s := newCache(t)
+	ctx := context.Background()
+
+	ok, err := cache.PutWhenEnable(ctx, "key", "v1", 60, consistent_cache.WithShadowExpireSeconds(600))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	s.FastForward(2 * time.Minute)
+	_, err = cache.Get(ctx, "key")
+	assert.ErrorIs(t, err, consistent_cache.ErrorCacheMiss)
+	v, err := cache.GetShadow(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", v)
+
+	assert.NoError(t, cache.Del(ctx, "key"))
+	_, err = cache.GetShadow(ctx, "key")
+	assert.ErrorIs(t, err, consistent_cache.ErrorCacheMiss)
+
+	// 禁用期间不写入影子副本
+	assert.NoError(t, cache.Disable(ctx, "key", "writer", 10))
+	ok, err = cache.PutWhenEnable(ctx, "key", "v2", 60, consistent_cache.WithShadowExpireSeconds(600))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, err = cache.GetShadow(ctx, "key")
+	assert.ErrorIs(t, err, consistent_cache.ErrorCacheMiss)
+	assert.NoError(t, cache.Enable(ctx, "key", "writer", 0))
+
+	ok, err = cache.PutWhenEnable(ctx, "key", "v3", 60, consistent_cache.WithShadowExpireSeconds(600))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = cache.Set(ctx, "key", "v4", 60, consistent_cache.WithVersion(1))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	_, err = cache.GetShadow(ctx, "key")
+	assert.ErrorIs(t, err, consistent_cache.ErrorCacheMiss)
+}
+
+func Test_RClient_Tracing(t *testing.T) {
+	mr := miniredis.RunT(t)
+	exporter := tracetest.NewInMemoryExporter()
+	cache := NewRedisCache(&Config{
+		Address:        mr.Addr(),
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)),
+	})
+
+	_, err := cache.Get(context.Background(), "key")
+	assert.ErrorIs(t, err, consistent_cache.ErrorCacheMiss)
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "redis.GET", spans[0].Name)
+	assert.Contains(t, spans[0].Attributes, attribute.String("cache.key", "key"))
+}
+
+// 验证点：1 锁被其他 token 持有时抢锁失败 2 持有者再次加锁时续期 3 只有持有者才能解锁
+func Test_Cache_Lock(t *testing.T) {
+	cache, mr := newCache(t)
+	ctx := context.Background()
+
+	locked, err := cache.Lock(ctx, "key", "a", 1000)
+	assert.NoError(t, err)
+	assert.True(t, locked)
+	locked, err = cache.Lock(ctx, "key", "b", 1000)
+	assert.NoError(t, err)
+	assert.False(t, locked)
+
+	mr.FastForward(800 * time.Millisecond)
+	locked, err = cache.Lock(ctx, "key", "a", 1000)
+	assert.NoError(t, err)
+	assert.True(t, locked)
+	mr.FastForward(800 * time.Millisecond)
+	locked, err = cache.Lock(ctx, "key", "b", 1000)
+	assert.NoError(t, err)
+	assert.False(t, locked)
+
+	assert.NoError(t, cache.Unlock(ctx, "key", "b"))
+	assert.NoError(t, cache.Unlock(ctx, "key", "a"))
+	locked, err = cache.Lock(ctx, "key", "b", 1000)
+	assert.NoError(t, err)
+	assert.True(t, locked)
+}
+
+// 验证点：兼容旧版本 string 结构的 disable key. 1 旧版本的禁用视为匿名写流程的禁用 2 新版本的写流程禁用时迁移为 hash 结构，旧版本的禁用在原过期时间后失效
+func Test_Cache_LegacyDisableKey(t *testing.T) {
+	cache, s := newCache(t)
+	ctx := context.Background()
+
+	legacyKey := cache.disableKey("key")
+	assert.NoError(t, s.Set(legacyKey, "1"))
+	s.SetTTL(legacyKey, 200*time.Millisecond)
+
+	enabled, err := cache.IsEnabled(ctx, "key")
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+	ok, err := cache.PutWhenEnable(ctx, "key", "v1", 60)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	oks, err := cache.MPutWhenEnable(ctx, []consistent_cache.CacheEntry{{Key: "key", Value: "v1", ExpireSeconds: 60}})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{false}, oks)
+	ok, err = cache.PutWhenEnable(ctx, "key", "v1", 60, consistent_cache.WithWriterToken("writer"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, cache.Enable(ctx, "key", "writer", 0))
+	assert.NoError(t, cache.MEnable(ctx, []string{"key"}, "writer", 0))
+
+	// 新版本的写流程禁用并撤销禁用后，旧版本的禁用仍然生效，直到原过期时间
+	assert.NoError(t, cache.Disable(ctx, "key", "writer", 10))
+	assert.NoError(t, cache.Enable(ctx, "key", "writer", 0))
+	enabled, err = cache.IsEnabled(ctx, "key")
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+	time.Sleep(300 * time.Millisecond)
+	ok, err = cache.PutWhenEnable(ctx, "key", "v2", 60)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
@@ -0,0 +1,62 @@
+package redis
+
+import (
+	"context"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// 基于 redis zset 实现的延时任务队列. zset 的 score 为任务的到期时间（毫秒时间戳），以 redis 服务端时间为基准
+type DelayQueue struct {
+	client *RClient
+	key    string
+}
+
+// 构造器函数. 所有使用相同 key 的进程共享同一个延时任务队列
+func NewDelayQueue(config *Config, key string) *DelayQueue {
+	return &DelayQueue{
+		client: NewRClient(config),
+		key:    key,
+	}
+}
+
+// 添加任务，任务在 delayMilis 之后到期
+func (q *DelayQueue) Add(ctx context.Context, delayMilis int64, tasks ...string) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	keysAndArgs := make([]interface{}, 0, len(tasks)+2)
+	keysAndArgs = append(keysAndArgs, q.key, delayMilis)
+	for _, task := range tasks {
+		keysAndArgs = append(keysAndArgs, task)
+	}
+	_, err := q.client.Eval(ctx, LuaDelayQueueAdd, 1, keysAndArgs)
+	return err
+}
+
+// 领取至多 limit 个已到期的任务
+func (q *DelayQueue) Claim(ctx context.Context, limit int, claimMilis int64) ([]string, error) {
+	reply, err := q.client.Eval(ctx, LuaDelayQueueClaim, 1, []interface{}{
+		q.key,
+		limit,
+		claimMilis,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return redis.Strings(reply, nil)
+}
+
+// 确认任务执行完成，从队列中删除任务
+func (q *DelayQueue) Remove(ctx context.Context, tasks ...string) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	return q.client.ZRem(ctx, q.key, tasks...)
+}
+
+// 队列中的任务数量
+func (q *DelayQueue) Len(ctx context.Context) (int64, error) {
+	return q.client.ZCard(ctx, q.key)
+}
@@ -0,0 +1,48 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// 验证点：1 任务到期后才能被领取 2 领取后的任务在领取时长内不会被重复领取，未删除则重新到期 3 删除后不再被领取
+func Test_DelayQueue(t *testing.T) {
+	mr := miniredis.RunT(t)
+	queue := NewDelayQueue(&Config{Address: mr.Addr()}, "delay_queue")
+	ctx := context.Background()
+
+	assert.NoError(t, queue.Add(ctx, 50, "task1", "task2"))
+	assert.NoError(t, queue.Add(ctx, 5000, "task3"))
+	n, err := queue.Len(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	// 任务尚未到期
+	tasks, err := queue.Claim(ctx, 10, 50)
+	assert.NoError(t, err)
+	assert.Empty(t, tasks)
+
+	// 任务到期后被领取，领取时长内不会被重复领取
+	time.Sleep(100 * time.Millisecond)
+	tasks, err = queue.Claim(ctx, 10, 50)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"task1", "task2"}, tasks)
+	tasks, err = queue.Claim(ctx, 10, 50)
+	assert.NoError(t, err)
+	assert.Empty(t, tasks)
+
+	// 确认 task1 执行完成，task2 在领取时长之后重新到期
+	assert.NoError(t, queue.Remove(ctx, "task1"))
+	time.Sleep(100 * time.Millisecond)
+	tasks, err = queue.Claim(ctx, 10, 50)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"task2"}, tasks)
+
+	n, err = queue.Len(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+}
@@ -1,11 +1,109 @@
 package redis
 
 const (
-	// 通过 lua 脚本确保在 disable key 不存在时，才执行 key value 对写入
-	LuaCheckEnableAndWriteCache = `
+	// 获取 redis 服务端的当前时间（毫秒时间戳）. 调用 time 指令后仍需执行写指令，因此需要开启指令复制模式
+	luaTimeFuncs = `
+	redis.replicate_commands();
+	local function now_milis()
+	    local now = redis.call("time");
+	    return tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000);
+	end
+`
+
+	// 各 lua 脚本公用的 disable key 相关函数
+	// disable key 为 hash 结构，field 为写流程的 token，value 为该写流程禁用读流程写缓存机制的截止时间（毫秒时间戳）
+	// 只有当全部写流程的截止时间都已经过去，读流程写缓存机制才会恢复为启用状态
+	// 兼容旧版本：旧版本的 disable key 为 string 结构，存在即表示禁用. 读取时视为一个匿名写流程的禁用，写入时迁移为 hash 结构
+	luaDisableFuncs = luaTimeFuncs + `
+	local legacy_token = "__legacy__";
+	local function is_legacy(disable_key)
+	    return redis.call("type",disable_key).ok == "string";
+	end
+	local function is_disabled(disable_key)
+	    if is_legacy(disable_key) then
+	        return true;
+	    end
+	    local now = now_milis();
+	    local fields = redis.call("hgetall",disable_key);
+	    for i = 2, #fields, 2 do
+	        if tonumber(fields[i]) > now then
+	            return true;
+	        end
+	    end
+	    return false;
+	end
+	local function set_deadline(disable_key, token, deadline)
+	    if is_legacy(disable_key) then
+	        local ttl = redis.call("pttl",disable_key);
+	        local legacy_deadline = deadline;
+	        if ttl > 0 then
+	            legacy_deadline = now_milis() + ttl;
+	        end
+	        redis.call("del",disable_key);
+	        redis.call("hset",disable_key,legacy_token,legacy_deadline);
+	    end
+	    redis.call("hset",disable_key,token,deadline);
+	    local now = now_milis();
+	    local max_deadline = 0;
+	    local fields = redis.call("hgetall",disable_key);
+	    for i = 1, #fields, 2 do
+	        local field_deadline = tonumber(fields[i+1]);
+	        if field_deadline <= now then
+	            redis.call("hdel",disable_key,fields[i]);
+	        elseif field_deadline > max_deadline then
+	            max_deadline = field_deadline;
+	        end
+	    end
+	    if max_deadline > now then
+	        redis.call("pexpire",disable_key,max_deadline-now);
+	    else
+	        redis.call("del",disable_key);
+	    end
+	end
+`
+
+	// 写流程 token 对应的截止时间设为 disable key 过期时间之后，并撤销 key 的租约
+	LuaDisableCache = luaDisableFuncs + `
+	local disable_key = KEYS[1];
+	local lease_key = KEYS[2];
+	local token = ARGV[1];
+	local disable_expire_seconds = tonumber(ARGV[2]);
+	set_deadline(disable_key,token,now_milis()+disable_expire_seconds*1000);
+	redis.call("del",lease_key);
+	return 1;
+`
+
+	// 写流程 token 对应的截止时间提前至 delay 之后. 截止时间只会提前，不会推后
+	LuaEnableCache = luaDisableFuncs + `
+	local disable_key = KEYS[1];
+	local token = ARGV[1];
+	local delay_milis = tonumber(ARGV[2]);
+	if is_legacy(disable_key) then
+	    return 0;
+	end
+	local cur_deadline = redis.call("hget",disable_key,token);
+	if not cur_deadline then
+	    return 0;
+	end
+	local deadline = now_milis() + delay_milis;
+	if deadline < tonumber(cur_deadline) then
+	    set_deadline(disable_key,token,deadline);
+	end
+	return 1;
+`
+
+	// 查询读流程写缓存机制是否启用. 启用时返回 1，否则返回 0
+	LuaCheckEnable = luaDisableFuncs + `
+	if is_disabled(KEYS[1]) then
+	    return 0;
+	end
+	return 1;
+`
+
+	// 通过 lua 脚本确保在读流程写缓存机制启用时，才执行 key value 对写入
+	LuaCheckEnableAndWriteCache = luaDisableFuncs + `
 	local disable_key = KEYS[1];
-	local disable_flag = redis.call("get",disable_key);
-	if disable_flag then
+	if is_disabled(disable_key) then
 	    return 0;
 	end
 	local key = KEYS[2];
@@ -15,4 +113,308 @@ const (
 	redis.call("expire",key,cache_expire_seconds);
 	return 1;
 `
+
+	// 在 LuaCheckEnableAndWriteCache 的基础上，额外校验版本号：只有不低于 version key 中已有版本号的数据才能写入
+	// 写入数据的同时更新 version key. 版本号为 -1 表示写入 NullData，存在版本号或版本号墓碑时拒绝写入，且不更新 version key
+	LuaCheckEnableAndVersionAndWriteCache = luaDisableFuncs + `
+	local disable_key = KEYS[1];
+	if is_disabled(disable_key) then
+	    return 0;
+	end
+	local version_key = KEYS[3];
+	local version = tonumber(ARGV[3]);
+	local cur_version = redis.call("get",version_key);
+	if cur_version and (version < 0 or tonumber(cur_version) > version) then
+	    return 0;
+	end
+	local key = KEYS[2];
+	local value = ARGV[1];
+	local cache_expire_seconds = tonumber(ARGV[2]);
+	redis.call("set",key,value,"ex",cache_expire_seconds);
+	if version > 0 then
+	    redis.call("set",version_key,version,"ex",cache_expire_seconds);
+	end
+	return 1;
+`
+
+	// 删除 key 及其影子副本并撤销 key 的租约，同时在 version key 中留下版本号墓碑. 墓碑只会调高，不会调低已有的版本号
+	LuaDeleteCacheAndWriteTombstone = `
+	local key = KEYS[1];
+	local version_key = KEYS[2];
+	local lease_key = KEYS[3];
+	local shadow_key = KEYS[4];
+	local version = tonumber(ARGV[1]);
+	local tombstone_expire_seconds = tonumber(ARGV[2]);
+	redis.call("del",key,lease_key,shadow_key);
+	local cur_version = redis.call("get",version_key);
+	if cur_version and tonumber(cur_version) >= version then
+	    return 0;
+	end
+	redis.call("set",version_key,version,"ex",tombstone_expire_seconds);
+	return 1;
+`
+
+	// 批量版本的 LuaCheckEnableAndVersionAndWriteCache. KEYS 按照 disable key、key、version key 三个一组排列
+	// ARGV 按照 value、过期时间、版本号三个一组排列，版本号为 0 表示不进行版本校验，为 -1 表示写入 NullData
+	// 返回结果为与每组 key 一一对应的 0/1 数组
+	LuaBatchCheckEnableAndWriteCache = luaDisableFuncs + `
+	local results = {};
+	for i = 1, #KEYS / 3 do
+	    local disable_key = KEYS[3*i-2];
+	    local key = KEYS[3*i-1];
+	    local version_key = KEYS[3*i];
+	    local value = ARGV[3*i-2];
+	    local cache_expire_seconds = tonumber(ARGV[3*i-1]);
+	    local version = tonumber(ARGV[3*i]);
+	    results[i] = 0;
+	    if not is_disabled(disable_key) then
+	        local cur_version = false;
+	        if version ~= 0 then
+	            cur_version = redis.call("get",version_key);
+	        end
+	        if not (cur_version and (version < 0 or tonumber(cur_version) > version)) then
+	            redis.call("set",key,value,"ex",cache_expire_seconds);
+	            if version > 0 then
+	                redis.call("set",version_key,version,"ex",cache_expire_seconds);
+	            end
+	            results[i] = 1;
+	        end
+	    end
+	end
+	return results;
+`
+
+	// 批量设置 disable key 并删除 key 及其影子副本、撤销 key 的租约. KEYS 按照 disable key、key、version key、lease key、shadow key 五个一组排列
+	// ARGV[1] 为写流程 token，ARGV[2] 为 disable key 过期时间，ARGV[3] 为版本号墓碑过期时间，ARGV[4] 开始为与每组 key 一一对应的版本号
+	// 版本号大于 0 时，在 version key 中留下版本号墓碑
+	LuaBatchDisableAndDeleteCache = luaDisableFuncs + `
+	local token = ARGV[1];
+	local disable_expire_seconds = tonumber(ARGV[2]);
+	local tombstone_expire_seconds = tonumber(ARGV[3]);
+	for i = 1, #KEYS / 5 do
+	    set_deadline(KEYS[5*i-4],token,now_milis()+disable_expire_seconds*1000);
+	    redis.call("del",KEYS[5*i-3],KEYS[5*i-1],KEYS[5*i]);
+	    local version_key = KEYS[5*i-2];
+	    local version = tonumber(ARGV[3+i]);
+	    if version > 0 then
+	        local cur_version = redis.call("get",version_key);
+	        if not (cur_version and tonumber(cur_version) >= version) then
+	            redis.call("set",version_key,version,"ex",tombstone_expire_seconds);
+	        end
+	    end
+	end
+	return 1;
+`
+
+	// 批量版本的 LuaEnableCache. KEYS 为 disable key，ARGV[1] 为写流程 token，ARGV[2] 为延时时间
+	LuaBatchEnableCache = luaDisableFuncs + `
+	local token = ARGV[1];
+	local delay_milis = tonumber(ARGV[2]);
+	for i = 1, #KEYS do
+	    local cur_deadline = nil;
+	    if not is_legacy(KEYS[i]) then
+	        cur_deadline = redis.call("hget",KEYS[i],token);
+	    end
+	    if cur_deadline then
+	        local deadline = now_milis() + delay_milis;
+	        if deadline < tonumber(cur_deadline) then
+	            set_deadline(KEYS[i],token,deadline);
+	        end
+	    end
+	end
+	return 1;
+`
+
+	// 在 key 的当前值仍为 value 的前提下写入影子副本. 保证写缓存之后并发的删除操作不会被影子副本的写入覆盖
+	LuaSetShadowIfCurrent = `
+	local key = KEYS[1];
+	local shadow_key = KEYS[2];
+	local value = ARGV[1];
+	local shadow_expire_seconds = tonumber(ARGV[2]);
+	if redis.call("get",key) ~= value then
+	    return 0;
+	end
+	redis.call("set",shadow_key,value,"ex",shadow_expire_seconds);
+	return 1;
+`
+
+	// 读取 key 以及 key 的剩余过期时间. 返回结果为 {1, value, pttl} 表示命中缓存，{0, 0} 表示缓存 miss
+	LuaGetWithTTL = `
+	local value = redis.call("get",KEYS[1]);
+	if not value then
+	    return {0, 0};
+	end
+	return {1, value, redis.call("pttl",KEYS[1])};
+`
+
+	// 读取 key. 倘若 key 不存在，则尝试为调用方授予 key 的租约，同一时刻一个 key 只会存在一份租约
+	// 返回结果为 {1, value} 表示命中缓存，{0, 1} 表示获得租约，{0, 0} 表示租约被其他调用方持有
+	LuaGetOrLease = `
+	local key = KEYS[1];
+	local lease_key = KEYS[2];
+	local value = redis.call("get",key);
+	if value then
+	    return {1, value};
+	end
+	local token = ARGV[1];
+	local lease_milis = tonumber(ARGV[2]);
+	if redis.call("set",lease_key,token,"nx","px",lease_milis) then
+	    return {0, 1};
+	end
+	return {0, 0};
+`
+
+	// 在读流程写缓存机制启用，且租约仍由当前 token 持有的前提下，才执行 key value 对写入，写入后租约失效
+	// 版本号大于 0 时，额外进行版本校验；版本号为 -1 表示写入 NullData，存在版本号或版本号墓碑时拒绝写入
+	LuaCheckLeaseAndWriteCache = luaDisableFuncs + `
+	local disable_key = KEYS[1];
+	local key = KEYS[2];
+	local lease_key = KEYS[3];
+	local version_key = KEYS[4];
+	local value = ARGV[1];
+	local cache_expire_seconds = tonumber(ARGV[2]);
+	local token = ARGV[3];
+	local version = tonumber(ARGV[4]);
+	if is_disabled(disable_key) then
+	    return 0;
+	end
+	if redis.call("get",lease_key) ~= token then
+	    return 0;
+	end
+	if version ~= 0 then
+	    local cur_version = redis.call("get",version_key);
+	    if cur_version and (version < 0 or tonumber(cur_version) > version) then
+	        return 0;
+	    end
+	end
+	if version > 0 then
+	    redis.call("set",version_key,version,"ex",cache_expire_seconds);
+	end
+	redis.call("set",key,value,"ex",cache_expire_seconds);
+	redis.call("del",lease_key);
+	return 1;
+`
+
+	// 以 redis 服务端时间为基准，向延时队列中添加到期时间为 delay 之后的任务. 已存在的任务会更新到期时间
+	// KEYS[1] 为延时队列对应的 zset，ARGV[1] 为延时时间，ARGV[2] 开始为任务
+	LuaDelayQueueAdd = luaTimeFuncs + `
+	local queue_key = KEYS[1];
+	local due_milis = now_milis() + tonumber(ARGV[1]);
+	for i = 2, #ARGV do
+	    redis.call("zadd",queue_key,due_milis,ARGV[i]);
+	end
+	return #ARGV - 1;
+`
+
+	// 领取至多 limit 个已到期的任务，并将其到期时间推后 claim 时长，避免在执行期间被其他调用方重复领取
+	// 倘若领取方未能在 claim 时长内确认任务完成，任务会重新到期
+	LuaDelayQueueClaim = luaTimeFuncs + `
+	local queue_key = KEYS[1];
+	local limit = tonumber(ARGV[1]);
+	local claim_milis = tonumber(ARGV[2]);
+	local now = now_milis();
+	local tasks = redis.call("zrangebyscore",queue_key,"-inf",now,"limit",0,limit);
+	for i = 1, #tasks do
+	    redis.call("zadd",queue_key,now+claim_milis,tasks[i]);
+	end
+	return tasks;
+`
+
+	// 写穿模式下，写流程以自身 token 的身份写缓存
+	// 要求 token 仍处于禁用期内，且不存在其他处于禁用期内的写流程：并发的写流程之间无法确定写 db 的先后顺序，此时放弃写缓存
+	// 版本号大于 0 时，额外进行版本校验
+	LuaCheckWriterAndWriteCache = luaDisableFuncs + `
+	local disable_key = KEYS[1];
+	local key = KEYS[2];
+	local version_key = KEYS[3];
+	local value = ARGV[1];
+	local cache_expire_seconds = tonumber(ARGV[2]);
+	local token = ARGV[3];
+	local version = tonumber(ARGV[4]);
+	local now = now_milis();
+	local owned = false;
+	if is_legacy(disable_key) then
+	    return 0;
+	end
+	local fields = redis.call("hgetall",disable_key);
+	for i = 1, #fields, 2 do
+	    local active = tonumber(fields[i+1]) > now;
+	    if fields[i] == token then
+	        owned = active;
+	    elseif active then
+	        return 0;
+	    end
+	end
+	if not owned then
+	    return 0;
+	end
+	if version > 0 then
+	    local cur_version = redis.call("get",version_key);
+	    if cur_version and tonumber(cur_version) > version then
+	        return 0;
+	    end
+	    redis.call("set",version_key,version,"ex",cache_expire_seconds);
+	end
+	redis.call("set",key,value,"ex",cache_expire_seconds);
+	return 1;
+`
+
+	// 写回模式下直接写缓存，不校验 disable key，只校验版本号不低于 version key 中已有的版本号. 写入成功时删除过时的影子副本
+	LuaCheckVersionAndSetCache = `
+	local key = KEYS[1];
+	local version_key = KEYS[2];
+	local shadow_key = KEYS[3];
+	local value = ARGV[1];
+	local cache_expire_seconds = tonumber(ARGV[2]);
+	local version = tonumber(ARGV[3]);
+	local cur_version = redis.call("get",version_key);
+	if cur_version and tonumber(cur_version) > version then
+	    return 0;
+	end
+	redis.call("set",key,value,"ex",cache_expire_seconds);
+	redis.call("set",version_key,version,"ex",cache_expire_seconds);
+	redis.call("del",shadow_key);
+	return 1;
+`
+
+	// 向写回缓冲区中追加一笔变更，返回追加序号. 缓冲区中的变更数量达到上限时返回 -1
+	// KEYS[1] 为缓冲区对应的 stream，KEYS[2] 为追加序号；ARGV[1] 为缓冲区容量上限，为 0 表示不限制
+	LuaWriteBufferAppend = `
+	local stream_key = KEYS[1];
+	local seq_key = KEYS[2];
+	local max_len = tonumber(ARGV[1]);
+	if max_len > 0 and redis.call("xlen",stream_key) >= max_len then
+	    return -1;
+	end
+	local seq = redis.call("incr",seq_key);
+	redis.call("xadd",stream_key,"*","seq",seq,"key",ARGV[2],"value",ARGV[3]);
+	return seq;
+`
+
+	// 锁未被持有时以 token 身份抢锁；锁已经由 token 持有时续期
+	LuaLockOrRenew = `
+	local lock_key = KEYS[1];
+	local token = ARGV[1];
+	local expire_milis = tonumber(ARGV[2]);
+	local holder = redis.call("get",lock_key);
+	if holder == token then
+	    redis.call("pexpire",lock_key,expire_milis);
+	    return 1;
+	end
+	if holder then
+	    return 0;
+	end
+	redis.call("set",lock_key,token,"px",expire_milis);
+	return 1;
+`
+
+	// 只有锁的持有者 token 与当前 token 一致时，才执行解锁操作
+	LuaCheckTokenAndUnlock = `
+	local lock_key = KEYS[1];
+	local token = ARGV[1];
+	if redis.call("get",lock_key) == token then
+	    return redis.call("del",lock_key);
+	end
+	return 0;
+`
 )
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/gomodule/redigo/redis"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Config struct {
@@ -17,16 +18,24 @@ type Config struct {
 	MaxActive int
 	// 当连接数达到上限时，新的请求是等待还是立即报错.
 	Wait bool
+	// 链路追踪. 非空时为每条 redis 指令创建 span
+	TracerProvider trace.TracerProvider
 }
 
 type RClient struct {
 	pool *redis.Pool
+	// 为空时不进行链路追踪
+	tracer trace.Tracer
 }
 
 func NewRClient(config *Config) *RClient {
-	return &RClient{
+	r := RClient{
 		pool: getRedisPool(config),
 	}
+	if config.TracerProvider != nil {
+		r.tracer = config.TracerProvider.Tracer(tracerName)
+	}
+	return &r
 }
 
 func getRedisPool(config *Config) *redis.Pool {
@@ -65,7 +74,7 @@ func (r *RClient) Get(ctx context.Context, key string) (string, error) {
 	if key == "" {
 		return "", errors.New("redis GET key can't be empty")
 	}
-	conn, err := r.pool.GetContext(ctx)
+	conn, err := r.getConn(ctx)
 	if err != nil {
 		return "", err
 	}
@@ -78,7 +87,7 @@ func (r *RClient) SetEx(ctx context.Context, key, value string, expireSeconds in
 	if key == "" {
 		return errors.New("redis SET EX key can't be empty")
 	}
-	conn, err := r.pool.GetContext(ctx)
+	conn, err := r.getConn(ctx)
 	if err != nil {
 		return err
 	}
@@ -88,20 +97,44 @@ func (r *RClient) SetEx(ctx context.Context, key, value string, expireSeconds in
 	return err
 }
 
-func (r *RClient) Del(ctx context.Context, key string) error {
-	if key == "" {
-		return errors.New("redis DEL key can't be empty")
+func (r *RClient) Del(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return errors.New("redis DEL keys can't be empty")
 	}
-	conn, err := r.pool.GetContext(ctx)
+	args := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		if key == "" {
+			return errors.New("redis DEL key can't be empty")
+		}
+		args = append(args, key)
+	}
+	conn, err := r.getConn(ctx)
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
 
-	_, err = conn.Do("DEL", key)
+	_, err = conn.Do("DEL", args...)
 	return err
 }
 
+func (r *RClient) MGet(ctx context.Context, keys []string) ([]interface{}, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("redis MGET keys can't be empty")
+	}
+	conn, err := r.getConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	args := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	return redis.Values(conn.Do("MGET", args...))
+}
+
 // Eval 支持使用 lua 脚本.
 func (r *RClient) Eval(ctx context.Context, src string, keyCount int, keysAndArgs []interface{}) (interface{}, error) {
 	args := make([]interface{}, 2+len(keysAndArgs))
@@ -109,7 +142,7 @@ func (r *RClient) Eval(ctx context.Context, src string, keyCount int, keysAndArg
 	args[1] = keyCount
 	copy(args[2:], keysAndArgs)
 
-	conn, err := r.pool.GetContext(ctx)
+	conn, err := r.getConn(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -118,13 +151,104 @@ func (r *RClient) Eval(ctx context.Context, src string, keyCount int, keysAndArg
 	return conn.Do("EVAL", args...)
 }
 
-func (r *RClient) PExpire(ctx context.Context, key string, expireMilis int64) error {
-	conn, err := r.pool.GetContext(ctx)
+func (r *RClient) ZRem(ctx context.Context, key string, members ...string) error {
+	if len(members) == 0 {
+		return errors.New("redis ZREM members can't be empty")
+	}
+	args := make([]interface{}, 0, len(members)+1)
+	args = append(args, key)
+	for _, member := range members {
+		args = append(args, member)
+	}
+	conn, err := r.getConn(ctx)
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
 
-	_, err = conn.Do("PEXPIRE", key, expireMilis)
+	_, err = conn.Do("ZREM", args...)
 	return err
 }
+
+func (r *RClient) ZCard(ctx context.Context, key string) (int64, error) {
+	conn, err := r.getConn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	return redis.Int64(conn.Do("ZCARD", key))
+}
+
+func (r *RClient) XRange(ctx context.Context, key string, count int) ([]interface{}, error) {
+	conn, err := r.getConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return redis.Values(conn.Do("XRANGE", key, "-", "+", "COUNT", count))
+}
+
+func (r *RClient) XDel(ctx context.Context, key string, ids ...string) error {
+	if len(ids) == 0 {
+		return errors.New("redis XDEL ids can't be empty")
+	}
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, key)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	conn, err := r.getConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("XDEL", args...)
+	return err
+}
+
+func (r *RClient) XLen(ctx context.Context, key string) (int64, error) {
+	conn, err := r.getConn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	return redis.Int64(conn.Do("XLEN", key))
+}
+
+func (r *RClient) Publish(ctx context.Context, channel, message string) error {
+	conn, err := r.getConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("PUBLISH", channel, message)
+	return err
+}
+
+// Subscribe 订阅 channel，每接收到一条消息就执行一次 handler. 会阻塞直到 ctx 终止或者连接出错
+func (r *RClient) Subscribe(ctx context.Context, channel string, handler func(message string)) error {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	psc := redis.PubSubConn{Conn: conn}
+	defer psc.Close()
+
+	if err = psc.Subscribe(channel); err != nil {
+		return err
+	}
+
+	for {
+		switch reply := psc.ReceiveContext(ctx).(type) {
+		case redis.Message:
+			handler(string(reply.Data))
+		case error:
+			return reply
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiaoxuxiansheng/consistent_cache"
+	"github.com/xiaoxuxiansheng/consistent_cache/lib/log"
+)
+
+var errDBDown = errors.New("db down")
+
+// 内存实现的数据库模块，down 时读写失败
+type kvDB struct {
+	consistent_cache.DB
+	mu     sync.Mutex
+	down   bool
+	values map[string]string
+	// 写入的数据存在对应 channel 时，阻塞直到 channel 关闭
+	blocks map[string]chan struct{}
+}
+
+func (d *kvDB) Get(ctx context.Context, obj consistent_cache.Object) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.down {
+		return errDBDown
+	}
+	v, ok := d.values[obj.Key()]
+	if !ok {
+		return consistent_cache.ErrorDBMiss
+	}
+	obj.(*bufferObject).V = v
+	return nil
+}
+
+func (d *kvDB) Put(ctx context.Context, obj consistent_cache.Object) error {
+	d.mu.Lock()
+	block := d.blocks[obj.(*bufferObject).V]
+	d.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.down {
+		return errDBDown
+	}
+	d.values[obj.Key()] = obj.(*bufferObject).V
+	return nil
+}
+
+func (d *kvDB) setDown(down bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.down = down
+}
+
+// 验证点：1 db 不可用时降级返回读流程写入的影子副本 2 写流程删除影子副本，写操作之后 db 不可用时不会返回写操作之前的旧数据
+func Test_Service_FailStatic(t *testing.T) {
+	cache, _ := newCache(t)
+	db := &kvDB{values: map[string]string{"key": "1"}}
+	service := consistent_cache.NewService(cache, db, consistent_cache.WithFailStatic(600),
+		consistent_cache.WithLogger(log.NewNopLogger()))
+	defer service.Close()
+	ctx := context.Background()
+
+	// 1 读流程写缓存时一并写入影子副本
+	_, err := service.Get(ctx, &bufferObject{K: "key"})
+	assert.NoError(t, err)
+	// 模拟缓存过期，影子副本仍然存在
+	assert.NoError(t, cache.client.Del(ctx, "key"))
+	db.setDown(true)
+	obj := &bufferObject{K: "key"}
+	info, err := service.GetWithInfo(ctx, obj)
+	assert.NoError(t, err)
+	assert.Equal(t, consistent_cache.GetInfo{UseCache: true, Degraded: true}, info)
+	assert.Equal(t, "1", obj.V)
+
+	// 2 写操作删除影子副本，此后 db 不可用时返回原始错误
+	db.setDown(false)
+	assert.NoError(t, service.Put(ctx, &bufferObject{K: "key", V: "2"}))
+	db.setDown(true)
+	_, err = service.GetWithInfo(ctx, &bufferObject{K: "key"})
+	assert.ErrorIs(t, err, errDBDown)
+}
+
+// 验证点：两个写流程 A、B 并发写同一个 key，B 先完成且延时启用结束后，A 仍在写 db，读流程读到的数据不能写缓存
+// 只有最后完成的写流程 A 延时启用结束后，读流程才能将 A 写入的数据写入缓存
+func Test_Service_OverlappingWriters(t *testing.T) {
+	cache, _ := newCache(t)
+	blockA := make(chan struct{})
+	db := &kvDB{values: map[string]string{"key": "0"}, blocks: map[string]chan struct{}{"a": blockA}}
+	service := consistent_cache.NewService(cache, db, consistent_cache.WithEnableDelayMilis(50),
+		consistent_cache.WithLogger(log.NewNopLogger()))
+	defer service.Close()
+	ctx := context.Background()
+
+	get := func() string {
+		obj := &bufferObject{K: "key"}
+		_, err := service.Get(ctx, obj)
+		assert.NoError(t, err)
+		return obj.V
+	}
+
+	// 1 写流程 A 禁用 key 后阻塞在写 db 的步骤
+	doneA := make(chan error)
+	go func() {
+		doneA <- service.Put(ctx, &bufferObject{K: "key", V: "a"})
+	}()
+	assert.Eventually(t, func() bool {
+		enabled, err := cache.IsEnabled(ctx, "key")
+		return err == nil && !enabled
+	}, time.Second, 10*time.Millisecond)
+
+	// 2 写流程 B 完成，且延时启用已经结束. A 仍在进行中，读流程不能写缓存
+	assert.NoError(t, service.Put(ctx, &bufferObject{K: "key", V: "b"}))
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, "b", get())
+	_, err := cache.Get(ctx, "key")
+	assert.ErrorIs(t, err, consistent_cache.ErrorCacheMiss)
+
+	// 3 写流程 A 完成后，延时启用结束前仍不能写缓存，结束后读流程写入 A 的数据
+	close(blockA)
+	assert.NoError(t, <-doneA)
+	assert.Equal(t, "a", get())
+	_, err = cache.Get(ctx, "key")
+	assert.ErrorIs(t, err, consistent_cache.ErrorCacheMiss)
+	assert.Eventually(t, func() bool {
+		get()
+		v, err := cache.Get(ctx, "key")
+		return err == nil && v == `{"k":"key","v":"a"}`
+	}, time.Second, 10*time.Millisecond)
+}
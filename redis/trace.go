@@ -0,0 +1,51 @@
+package redis
+
+import (
+	"context"
+
+	"github.com/gomodule/redigo/redis"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/xiaoxuxiansheng/consistent_cache/redis"
+
+// 从连接池中获取连接. 开启链路追踪时，连接上执行的每条指令都会创建一个 ctx 的子 span
+func (r *RClient) getConn(ctx context.Context) (redis.Conn, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil || r.tracer == nil {
+		return conn, err
+	}
+	return &tracedConn{Conn: conn, ctx: ctx, tracer: r.tracer}, nil
+}
+
+// 为每条指令创建 span 的连接
+type tracedConn struct {
+	redis.Conn
+	ctx    context.Context
+	tracer trace.Tracer
+}
+
+func (c *tracedConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system", "redis"),
+		attribute.String("db.operation", cmd),
+	}
+	// EVAL 指令的首个参数为脚本内容，其余指令的首个参数为 key
+	if len(args) > 0 && cmd != "EVAL" {
+		if key, ok := args[0].(string); ok {
+			attrs = append(attrs, attribute.String("cache.key", key))
+		}
+	}
+
+	_, span := c.tracer.Start(c.ctx, "redis."+cmd, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	defer span.End()
+
+	reply, err := c.Conn.Do(cmd, args...)
+	if err != nil && err != redis.ErrNil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return reply, err
+}
@@ -0,0 +1,99 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/spf13/cast"
+	"github.com/xiaoxuxiansheng/consistent_cache"
+)
+
+// 基于 redis stream 实现的写回缓冲区. 变更在写入 db 并确认之前一直保留在 stream 中，进程崩溃后可以重新读取
+type WriteBuffer struct {
+	client *RClient
+	key    string
+	// 缓冲区中的变更数量上限，为 0 表示不限制
+	maxLen int64
+}
+
+// 构造器函数. 所有使用相同 key 的进程共享同一个缓冲区
+func NewWriteBuffer(config *Config, key string, maxLen int64) *WriteBuffer {
+	return &WriteBuffer{
+		client: NewRClient(config),
+		key:    key,
+		maxLen: maxLen,
+	}
+}
+
+// 追加一笔变更，返回单调递增的追加序号. 缓冲区已满时返回 ErrorWriteBufferFull
+func (b *WriteBuffer) Append(ctx context.Context, key, value string) (int64, error) {
+	reply, err := b.client.Eval(ctx, LuaWriteBufferAppend, 2, []interface{}{
+		b.key,
+		b.seqKey(),
+		b.maxLen,
+		key,
+		value,
+	})
+	if err != nil {
+		return 0, err
+	}
+	seq := cast.ToInt64(reply)
+	if seq < 0 {
+		return 0, consistent_cache.ErrorWriteBufferFull
+	}
+	return seq, nil
+}
+
+// 按照追加顺序读取至多 limit 笔尚未确认的变更
+func (b *WriteBuffer) Read(ctx context.Context, limit int) ([]consistent_cache.BufferEntry, error) {
+	replies, err := b.client.XRange(ctx, b.key, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// 每笔变更的格式为 [id, [field1, value1, field2, value2 ...]]
+	entries := make([]consistent_cache.BufferEntry, 0, len(replies))
+	for _, reply := range replies {
+		parts, err := redis.Values(reply, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid xrange entry len: %d, expect: 2", len(parts))
+		}
+		id, err := redis.String(parts[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		fields, err := redis.StringMap(parts[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, consistent_cache.BufferEntry{
+			ID:    id,
+			Seq:   cast.ToInt64(fields["seq"]),
+			Key:   fields["key"],
+			Value: fields["value"],
+		})
+	}
+	return entries, nil
+}
+
+// 确认变更已经写入 db，从缓冲区中删除
+func (b *WriteBuffer) Ack(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return b.client.XDel(ctx, b.key, ids...)
+}
+
+// 缓冲区中尚未确认的变更数量
+func (b *WriteBuffer) Len(ctx context.Context) (int64, error) {
+	return b.client.XLen(ctx, b.key)
+}
+
+// 存储追加序号的 key. 通过 {hash_tag} 保证在 redis 集群模式下与缓冲区分发到相同节点
+func (b *WriteBuffer) seqKey() string {
+	return fmt.Sprintf("Write_Buffer_Seq_Key_{%s}", b.key)
+}
@@ -0,0 +1,155 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/xiaoxuxiansheng/consistent_cache"
+	"github.com/xiaoxuxiansheng/consistent_cache/lib/log"
+)
+
+// 验证点：1 按照追加顺序读取变更 2 确认后的变更不再被读取 3 缓冲区已满时拒绝追加
+func Test_WriteBuffer(t *testing.T) {
+	mr := miniredis.RunT(t)
+	buffer := NewWriteBuffer(&Config{Address: mr.Addr()}, "write_buffer", 3)
+	ctx := context.Background()
+
+	seq1, err := buffer.Append(ctx, "key1", "v1")
+	assert.NoError(t, err)
+	seq2, err := buffer.Append(ctx, "key2", "v2")
+	assert.NoError(t, err)
+	seq3, err := buffer.Append(ctx, "key1", "v3")
+	assert.NoError(t, err)
+	assert.True(t, seq1 < seq2 && seq2 < seq3)
+
+	// 缓冲区已满
+	_, err = buffer.Append(ctx, "key3", "v4")
+	assert.ErrorIs(t, err, consistent_cache.ErrorWriteBufferFull)
+
+	entries, err := buffer.Read(ctx, 2)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "key1", entries[0].Key)
+	assert.Equal(t, "v1", entries[0].Value)
+	assert.Equal(t, seq1, entries[0].Seq)
+	assert.Equal(t, "key2", entries[1].Key)
+	assert.Equal(t, "v2", entries[1].Value)
+
+	// 确认前两笔变更后，只剩下第三笔
+	assert.NoError(t, buffer.Ack(ctx, entries[0].ID, entries[1].ID))
+	n, err := buffer.Len(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	entries, err = buffer.Read(ctx, 10)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "v3", entries[0].Value)
+
+	// 缓冲区有空余后允许追加
+	_, err = buffer.Append(ctx, "key3", "v4")
+	assert.NoError(t, err)
+}
+
+// 验证点：写回模式下直接写缓存时，拒绝写入低于已有版本号的数据，且不受 disable 影响
+func Test_Cache_Set(t *testing.T) {
+	cache, _ := newCache(t)
+	ctx := context.Background()
+
+	assert.NoError(t, cache.Disable(ctx, "key", "writer", 10))
+	ok, err := cache.Set(ctx, "key", "v2", 60, consistent_cache.WithVersion(2))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = cache.Set(ctx, "key", "v1", 60, consistent_cache.WithVersion(1))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	v, err := cache.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", v)
+}
+
+type bufferObject struct {
+	K string `json:"k"`
+	V string `json:"v"`
+}
+
+func (o *bufferObject) KeyColumn() string { return "k" }
+func (o *bufferObject) Key() string       { return o.K }
+func (o *bufferObject) Write() (string, error) {
+	body, err := json.Marshal(o)
+	return string(body), err
+}
+func (o *bufferObject) Read(body string) error { return json.Unmarshal([]byte(body), o) }
+
+// 批量写入数据的数据库模块，down 时写入失败
+type bufferDB struct {
+	consistent_cache.DB
+	mu     sync.Mutex
+	down   bool
+	values map[string]string
+}
+
+func (d *bufferDB) MPut(ctx context.Context, objs []consistent_cache.Object) []error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	errs := make([]error, len(objs))
+	for i, obj := range objs {
+		if d.down {
+			errs[i] = errors.New("db down")
+			continue
+		}
+		d.values[obj.Key()] = obj.(*bufferObject).V
+	}
+	return errs
+}
+
+// 验证点：实例在变更写入 db 之前崩溃，重启后的实例基于同一个缓冲区将变更写入 db，并撤销对读流程写缓存的禁用
+func Test_WriteBuffer_Recovery(t *testing.T) {
+	mr := miniredis.RunT(t)
+	config := &Config{Address: mr.Addr()}
+	newObject := func() consistent_cache.Object { return &bufferObject{} }
+	ctx := context.Background()
+
+	// 1 db 不可用，变更只保留在缓冲区中
+	crashed := &bufferDB{down: true, values: make(map[string]string)}
+	service := consistent_cache.NewService(NewRedisCache(config), crashed,
+		consistent_cache.WithWriteBehind(NewWriteBuffer(config, "write_buffer", 0), newObject),
+		consistent_cache.WithLogger(log.NewNopLogger()))
+	assert.NoError(t, service.Put(ctx, &bufferObject{K: "a", V: "1"}))
+	assert.NoError(t, service.Put(ctx, &bufferObject{K: "a", V: "2"}))
+	assert.NoError(t, service.Put(ctx, &bufferObject{K: "b", V: "1"}))
+	service.Close()
+
+	cache := NewRedisCache(config)
+	enabled, err := cache.IsEnabled(ctx, "a")
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+
+	// 2 重启后的实例将未确认的变更写入 db
+	db := &bufferDB{values: make(map[string]string)}
+	restarted := consistent_cache.NewService(NewRedisCache(config), db,
+		consistent_cache.WithWriteBehind(NewWriteBuffer(config, "write_buffer", 0), newObject),
+		consistent_cache.WithEnableDelayMilis(100),
+		consistent_cache.WithLogger(log.NewNopLogger()))
+	defer restarted.Close()
+	assert.NoError(t, restarted.Flush(ctx))
+	db.mu.Lock()
+	assert.Equal(t, map[string]string{"a": "2", "b": "1"}, db.values)
+	db.mu.Unlock()
+	n, err := NewWriteBuffer(config, "write_buffer", 0).Len(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+
+	// 3 延时结束后撤销禁用
+	assert.Eventually(t, func() bool {
+		mr.FastForward(time.Second)
+		enabled, err := cache.IsEnabled(ctx, "a")
+		return err == nil && enabled
+	}, time.Second, 10*time.Millisecond)
+}
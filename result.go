@@ -0,0 +1,186 @@
+package consistent_cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// 读操作返回数据的来源
+type Source int
+
+const (
+	// 未读取到数据，例如读操作出错
+	SourceNone Source = iota
+	// 进程内的本地缓存
+	SourceL1
+	// 缓存模块
+	SourceCache
+	// db 或者 GetOrLoad 中使用方提供的 loader
+	SourceDB
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceL1:
+		return "l1"
+	case SourceCache:
+		return "cache"
+	case SourceDB:
+		return "db"
+	default:
+		return "none"
+	}
+}
+
+// 读流程写缓存的结果
+type FillOutcome int
+
+const (
+	// 未写缓存，例如命中缓存或者共享了其他调用方的加载结果
+	FillNone FillOutcome = iota
+	// 写缓存成功
+	FillAccepted
+	// 写缓存被拒绝，例如处于禁用状态、租约失效或者版本号过低
+	FillRejected
+	// 写缓存出错，错误只打印日志，不影响读操作的结果
+	FillError
+)
+
+func (f FillOutcome) String() string {
+	switch f {
+	case FillAccepted:
+		return "accepted"
+	case FillRejected:
+		return "rejected"
+	case FillError:
+		return "error"
+	default:
+		return "none"
+	}
+}
+
+// 读操作的详细结果
+type GetResult struct {
+	GetInfo
+	// 数据来源
+	Source Source
+	// 是否命中缓存中的 NullData
+	NegativeHit bool
+	// 读流程写缓存的结果，以及写缓存出错时的错误
+	Fill    FillOutcome
+	FillErr error
+	// 读取到的缓存数据的剩余过期时间. 未读取到缓存或者剩余过期时间未知时为 -1
+	TTL time.Duration
+	// 读取缓存的耗时（包含本地缓存）以及加载数据的耗时
+	CacheLatency time.Duration
+	DBLatency    time.Duration
+}
+
+// 读操作过程中的记录，通过 ctx 传递
+type getRecorder struct {
+	mu           sync.Mutex
+	source       Source
+	fill         FillOutcome
+	fillErr      error
+	ttlMilis     int64
+	cacheLatency time.Duration
+	dbLatency    time.Duration
+}
+
+type getRecorderKey struct{}
+
+func withGetRecorder(ctx context.Context) (context.Context, *getRecorder) {
+	r := getRecorder{ttlMilis: -1}
+	return context.WithValue(ctx, getRecorderKey{}, &r), &r
+}
+
+// 获取 ctx 中的记录. 不在读操作过程中时返回 nil，nil 的记录上的操作均为空操作
+func getRecorderFrom(ctx context.Context) *getRecorder {
+	r, _ := ctx.Value(getRecorderKey{}).(*getRecorder)
+	return r
+}
+
+// 由多级缓存的实现方在命中某一层缓存时调用，上报数据来源. 例如本地缓存命中时上报 SourceL1
+func ReportCacheSource(ctx context.Context, source Source) {
+	if r := getRecorderFrom(ctx); r != nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.source = source
+	}
+}
+
+// 记录一次读缓存. 命中缓存且尚未有缓存模块上报数据来源时，来源记为 SourceCache
+func (r *getRecorder) recordCache(cost time.Duration, ttlMilis int64, hit bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cacheLatency += cost
+	if !hit {
+		return
+	}
+	r.ttlMilis = ttlMilis
+	if r.source == SourceNone {
+		r.source = SourceCache
+	}
+}
+
+// 记录一次加载数据
+func (r *getRecorder) recordLoad(cost time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dbLatency += cost
+	r.source = SourceDB
+	r.ttlMilis = -1
+}
+
+// 记录一次读流程写缓存
+func (r *getRecorder) recordFill(ok bool, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case err != nil:
+		r.fill, r.fillErr = FillError, err
+	case ok:
+		r.fill = FillAccepted
+	default:
+		r.fill = FillRejected
+	}
+}
+
+// 基于读操作的返回结果构造详细结果
+func (r *getRecorder) result(info GetInfo, err error) GetResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := GetResult{
+		GetInfo:      info,
+		Source:       r.source,
+		NegativeHit:  info.UseCache && errors.Is(err, ErrorDataNotExist),
+		Fill:         r.fill,
+		FillErr:      r.fillErr,
+		TTL:          -1,
+		CacheLatency: r.cacheLatency,
+		DBLatency:    r.dbLatency,
+	}
+	if r.ttlMilis >= 0 {
+		res.TTL = time.Duration(r.ttlMilis) * time.Millisecond
+	}
+	// 共享了其他调用方的加载结果
+	if res.Source == SourceNone && (err == nil || errors.Is(err, ErrorDataNotExist)) {
+		res.Source = SourceDB
+		if info.UseCache {
+			res.Source = SourceCache
+		}
+	}
+	return res
+}
@@ -0,0 +1,58 @@
+package consistent_cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 返回固定剩余过期时间的缓存模块
+type ttlCache struct {
+	*memoryCache
+	ttlMilis int64
+}
+
+func (c *ttlCache) GetWithTTL(ctx context.Context, key string) (string, int64, error) {
+	v, err := c.Get(ctx, key)
+	return v, c.ttlMilis, err
+}
+
+// 验证点：1 缓存 miss 时数据来源为 db，并返回写缓存的结果 2 命中缓存时返回剩余过期时间 3 命中 NullData 时标识为负缓存命中
+func Test_GetWithResult(t *testing.T) {
+	cache := &ttlCache{memoryCache: newMemoryCache(), ttlMilis: 5000}
+	service := newTestService(cache, &counterDB{count: "1"})
+	ctx := context.Background()
+
+	obj := &counterObject{K: "key"}
+	res, err := service.GetWithResult(ctx, obj)
+	assert.NoError(t, err)
+	assert.False(t, res.UseCache)
+	assert.Equal(t, SourceDB, res.Source)
+	assert.Equal(t, FillAccepted, res.Fill)
+	assert.Equal(t, time.Duration(-1), res.TTL)
+
+	obj = &counterObject{K: "key"}
+	res, err = service.GetWithResult(ctx, obj)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", obj.Count)
+	assert.True(t, res.UseCache)
+	assert.Equal(t, SourceCache, res.Source)
+	assert.Equal(t, FillNone, res.Fill)
+	assert.Equal(t, 5*time.Second, res.TTL)
+	assert.Zero(t, res.DBLatency)
+
+	cache.set("null", NullData)
+	res, err = service.GetWithResult(ctx, &counterObject{K: "null"})
+	assert.ErrorIs(t, err, ErrorDataNotExist)
+	assert.True(t, res.NegativeHit)
+	assert.Equal(t, SourceCache, res.Source)
+
+	// 处于禁用状态时写缓存被拒绝
+	cache.setEnabled(false)
+	res, err = service.GetWithResult(ctx, &counterObject{K: "other"})
+	assert.NoError(t, err)
+	assert.Equal(t, SourceDB, res.Source)
+	assert.Equal(t, FillRejected, res.Fill)
+}
@@ -0,0 +1,145 @@
+package consistent_cache
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// 重试策略. 通过 WithRetryPolicy 作用于写流程的每个步骤、读流程的读缓存和写缓存，以及后台的延时启用操作
+type RetryPolicy interface {
+	// 第 attempt 次（从 1 开始）执行失败后，判断是否需要重试，并返回重试前的等待时间. elapsed 为自首次执行以来经过的时长
+	Next(attempt int, elapsed time.Duration, err error) (time.Duration, bool)
+}
+
+const (
+	// 默认首次重试前等待 10 ms
+	DefaultRetryInitialInterval = 10 * time.Millisecond
+	// 默认重试等待时间的上限为 1 s
+	DefaultRetryMaxInterval = time.Second
+	// 默认等待时间每次翻倍
+	DefaultRetryMultiplier = 2
+	// 默认自首次执行起，超过 5 s 后不再重试
+	DefaultRetryMaxElapsedTime = 5 * time.Second
+)
+
+// 带有随机抖动的指数退避重试策略. 字段为零值时使用对应的默认值
+type ExponentialBackoff struct {
+	// 首次重试前的等待时间
+	InitialInterval time.Duration
+	// 重试等待时间的上限
+	MaxInterval time.Duration
+	// 每次重试后等待时间的增长倍数
+	Multiplier float64
+	// 抖动比例，取值范围 [0, 1]. 实际等待时间在 [interval*(1-Jitter), interval*(1+Jitter)] 内随机
+	Jitter float64
+	// 自首次执行起，超过该时长后不再重试
+	MaxElapsedTime time.Duration
+	// 最大重试次数. 为 0 时不限制，只受 MaxElapsedTime 约束
+	MaxRetries int
+	// 判断错误是否可以重试. 为空时使用 IsRetryable
+	Retryable func(err error) bool
+}
+
+var _ RetryPolicy = (*ExponentialBackoff)(nil)
+
+func (b *ExponentialBackoff) Next(attempt int, elapsed time.Duration, err error) (time.Duration, bool) {
+	retryable := b.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	if !retryable(err) {
+		return 0, false
+	}
+	if b.MaxRetries > 0 && attempt > b.MaxRetries {
+		return 0, false
+	}
+
+	maxElapsedTime := b.MaxElapsedTime
+	if maxElapsedTime <= 0 {
+		maxElapsedTime = DefaultRetryMaxElapsedTime
+	}
+	if elapsed >= maxElapsedTime {
+		return 0, false
+	}
+
+	// 第 attempt 次重试的等待时间为 initial * multiplier^(attempt-1)，不超过上限
+	interval, maxInterval, multiplier := b.InitialInterval, b.MaxInterval, b.Multiplier
+	if interval <= 0 {
+		interval = DefaultRetryInitialInterval
+	}
+	if maxInterval <= 0 {
+		maxInterval = DefaultRetryMaxInterval
+	}
+	if multiplier < 1 {
+		multiplier = DefaultRetryMultiplier
+	}
+	wait := float64(interval)
+	for i := 1; i < attempt && wait < float64(maxInterval); i++ {
+		wait *= multiplier
+	}
+	if wait > float64(maxInterval) {
+		wait = float64(maxInterval)
+	}
+
+	// 添加随机抖动，避免大量调用方同时重试
+	if b.Jitter > 0 {
+		wait *= 1 - b.Jitter + 2*b.Jitter*rand.Float64()
+	}
+	return time.Duration(wait), true
+}
+
+// 默认的可重试错误判断. 缓存 miss、数据不存在、缓存不可用、缓冲区已满以及 ctx 终止类错误不进行重试
+func IsRetryable(err error) bool {
+	switch {
+	case err == nil,
+		errors.Is(err, ErrorCacheMiss),
+		errors.Is(err, ErrorDBMiss),
+		errors.Is(err, ErrorDataNotExist),
+		errors.Is(err, ErrorCacheUnavailable),
+		errors.Is(err, ErrorWriteBufferFull),
+		isContextErr(err):
+		return false
+	default:
+		return true
+	}
+}
+
+// 按照重试策略执行 f. 未设置重试策略时只执行一次
+// 等待时间超出 ctx 的截止时间，或者等待期间 ctx 终止时，不再重试并返回最后一次执行的错误
+func (s *Service) retry(ctx context.Context, f func(ctx context.Context) error) error {
+	return s.retryWith(ctx, s.opts.retryPolicy, f)
+}
+
+// 按照指定的重试策略执行 f. policy 为空时只执行一次
+func (s *Service) retryWith(ctx context.Context, policy RetryPolicy, f func(ctx context.Context) error) error {
+	if policy == nil {
+		return f(ctx)
+	}
+
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := f(ctx)
+		if err == nil {
+			return nil
+		}
+
+		wait, ok := policy.Next(attempt, time.Since(start), err)
+		if !ok {
+			return err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return err
+		}
+
+		s.opts.logger.Warnf("retry after %v, attempt: %d, err: %v", wait, attempt, err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
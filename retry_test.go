@@ -0,0 +1,74 @@
+package consistent_cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ExponentialBackoff(t *testing.T) {
+	policy := &ExponentialBackoff{InitialInterval: 10 * time.Millisecond, MaxInterval: 50 * time.Millisecond, MaxRetries: 4}
+	errTimeout := errors.New("i/o timeout")
+
+	for attempt, expect := range []time.Duration{10, 20, 40, 50} {
+		wait, ok := policy.Next(attempt+1, 0, errTimeout)
+		assert.True(t, ok)
+		assert.Equal(t, expect*time.Millisecond, wait)
+	}
+	// 超过最大重试次数、超过最大时长以及不可重试的错误
+	_, ok := policy.Next(5, 0, errTimeout)
+	assert.False(t, ok)
+	_, ok = policy.Next(1, DefaultRetryMaxElapsedTime, errTimeout)
+	assert.False(t, ok)
+	_, ok = policy.Next(1, 0, ErrorCacheMiss)
+	assert.False(t, ok)
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		wait, _ := policy.Next(2, 0, errTimeout)
+		assert.GreaterOrEqual(t, wait, 10*time.Millisecond)
+		assert.LessOrEqual(t, wait, 30*time.Millisecond)
+	}
+}
+
+// 前 failures 次禁用操作失败的缓存模块
+type flakyCache struct {
+	Cache
+	failures int
+	disables int
+}
+
+func (c *flakyCache) Disable(ctx context.Context, key, token string, expireSeconds int64) error {
+	if c.disables++; c.disables <= c.failures {
+		return errors.New("i/o timeout")
+	}
+	return nil
+}
+
+func (c *flakyCache) Enable(ctx context.Context, key, token string, delayMilis int64) error {
+	return nil
+}
+
+func (c *flakyCache) Del(ctx context.Context, key string, opts ...CacheOption) error {
+	return nil
+}
+
+// 验证点：1 写流程的单个步骤失败后按策略重试 2 重试等待时间超出 ctx 截止时间时不再重试
+func Test_Retry(t *testing.T) {
+	cache := &flakyCache{failures: 2}
+	service := newTestService(cache, putOnlyDB{}, WithRetryPolicy(&ExponentialBackoff{InitialInterval: time.Millisecond}))
+	ctx := context.Background()
+
+	assert.NoError(t, service.Put(ctx, &strategyObject{key: "key"}))
+	assert.Equal(t, 3, cache.disables)
+
+	cache = &flakyCache{failures: 2}
+	service = newTestService(cache, putOnlyDB{}, WithRetryPolicy(&ExponentialBackoff{InitialInterval: time.Second}))
+	tctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	assert.Error(t, service.Put(tctx, &strategyObject{key: "key"}))
+	assert.Equal(t, 1, cache.disables)
+}
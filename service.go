@@ -3,7 +3,10 @@ package consistent_cache
 import (
 	"context"
 	"errors"
+	"sync"
 	"time"
+
+	"github.com/xiaoxuxiansheng/consistent_cache/lib/singleflight"
 )
 
 // 一致性缓存服务
@@ -14,14 +17,29 @@ type Service struct {
 	cache Cache
 	// 数据库模块
 	db DB
+	// 一致性策略，决定写流程中缓存失效的方式
+	strategy strategy
+	// 缓存 miss 合并. 仅在开启 WithMissCoalescing 时非空
+	group *singleflight.Group
+	// 停止后台协程（延时任务队列的 worker、写回协程）. 仅在开启 WithDelayQueue 或 WithWriteBehind 时非空
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// 通知写回协程立即执行一次写回
+	flushc chan struct{}
+	// 观测到的加载耗时，单位：纳秒. 供提前刷新使用
+	loadNanos int64
+	// 正在后台刷新过期数据的 key
+	mu         sync.Mutex
+	refreshing map[string]struct{}
 }
 
 // 构造一致性缓存服务. 缓存和数据库均由使用方提供具体的实现版本
 func NewService(cache Cache, db DB, opts ...Option) *Service {
 	s := Service{
-		cache: cache,
-		db:    db,
-		opts:  &Options{},
+		cache:      cache,
+		db:         db,
+		opts:       &Options{},
+		refreshing: make(map[string]struct{}),
 	}
 
 	for _, opt := range opts {
@@ -29,81 +47,448 @@ func NewService(cache Cache, db DB, opts ...Option) *Service {
 	}
 
 	repair(s.opts)
+
+	s.strategy = newStrategy(&s)
+	if s.opts.missCoalescing {
+		s.group = singleflight.NewGroup()
+	}
+
+	if s.opts.delayQueue == nil && s.opts.writeBuffer == nil {
+		return &s
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	if s.opts.delayQueue != nil {
+		s.wg.Add(1)
+		go s.runDelayQueue(ctx)
+	}
+	if s.opts.writeBuffer != nil {
+		s.flushc = make(chan struct{}, 1)
+		s.wg.Add(1)
+		go s.runWriteBehind(ctx)
+	}
 	return &s
 }
 
+// 停止后台协程. 尚未执行的延时任务仍保留在队列中，由其他 Service 实例或者重启后的实例执行
+// 开启写回模式时，会在限定时间内将缓冲区中的变更写入 db，未写入的变更仍保留在缓冲区中
+func (s *Service) Close() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.wg.Wait()
+
+	if s.opts.writeBuffer == nil {
+		return
+	}
+	tctx, cancel := context.WithTimeout(context.Background(), DefaultWriteBehindCloseMilis*time.Millisecond)
+	defer cancel()
+	if err := s.Flush(tctx); err != nil {
+		s.opts.logger.Errorf("flush write buffer on close fail, err: %v", err)
+	}
+}
+
 // 写操作
 func (s *Service) Put(ctx context.Context, obj Object) error {
-	// 1 针对 key 维度禁用读流程写缓存机制
-	if err := s.cache.Disable(ctx, obj.Key(), s.opts.disableExpireSeconds); err != nil {
-		return err
+	return chainPut(s.opts.putInterceptors, s.put)(ctx, obj)
+}
+
+func (s *Service) put(ctx context.Context, obj Object) (err error) {
+	ctx, span := s.startSpan(ctx, "consistent_cache.Put", obj.Key())
+	defer func() { endSpan(span, err) }()
+
+	if s.opts.writeBuffer != nil {
+		return s.putBehind(ctx, obj)
 	}
 
-	defer func() {
-		go func() {
-			tctx, cancel := context.WithTimeout(context.Background(), time.Second)
-			defer cancel()
-			if err := s.cache.Enable(tctx, obj.Key(), s.opts.enableDelayMilis); err != nil {
-				s.opts.logger.Errorf("enable fail, key: %s, err: %v", obj.Key(), err)
-			}
-		}()
-	}()
+	return s.write(ctx, writeOp{
+		key: obj.Key(),
+		obj: obj,
+		write: func(ctx context.Context) error {
+			// 数据写入 db
+			return s.db.Put(ctx, obj)
+		},
+		writeThrough: s.opts.writeThrough,
+		delOpts:      s.tombstoneOptions(obj),
+	})
+}
+
+// 删除操作. 流程与写操作一致，只是由写 db 改为从 db 中删除记录
+func (s *Service) Del(ctx context.Context, obj Object) error {
+	return chainPut(s.opts.putInterceptors, s.del)(withWriteKind(ctx, WriteKindDel), obj)
+}
 
-	// 2 删除 key 维度对应缓存
-	if err := s.cache.Del(ctx, obj.Key()); err != nil {
+func (s *Service) del(ctx context.Context, obj Object) (err error) {
+	ctx, span := s.startSpan(ctx, "consistent_cache.Del", obj.Key())
+	defer func() { endSpan(span, err) }()
+
+	// 写回模式下，先将缓冲区中的变更全部写入 db，避免先前追加的变更在删除之后写入 db，使数据重新出现
+	if err := s.Flush(ctx); err != nil {
 		return err
 	}
 
-	// 3 数据写入 db
-	return s.db.Put(ctx, obj)
+	// 禁用读流程写缓存机制，保证并发读流程不会把删除前的旧数据重新写回缓存
+	// 带有版本号的数据删除缓存时留下版本号墓碑
+	return s.write(ctx, writeOp{
+		key: obj.Key(),
+		obj: obj,
+		write: func(ctx context.Context) error {
+			// 从 db 中删除数据
+			return s.db.Delete(ctx, obj)
+		},
+		delOpts: s.tombstoneOptions(obj),
+	})
+}
+
+// 使 key 对应缓存失效. 流程与写操作一致，只是不涉及 db 写操作
+// 适用于数据源不是 db 的场景，在数据源变更后调用
+func (s *Service) Invalidate(ctx context.Context, key string) error {
+	return chainPut(s.opts.putInterceptors, func(ctx context.Context, obj Object) error {
+		return s.write(ctx, writeOp{key: obj.Key()})
+	})(withWriteKind(ctx, WriteKindInvalidate), invalidateObject{key: key})
 }
 
-// 2 读操作
-func (s *Service) Get(ctx context.Context, obj Object) (useCache bool, err error) {
+// 写流程中的一次写操作
+type writeOp struct {
+	key string
+	// 写操作对应的数据，Invalidate 时为 nil
+	obj Object
+	// 写操作，为 nil 时只使缓存失效
+	write func(ctx context.Context) error
+	// 写操作成功后，是否将 obj 写入缓存
+	writeThrough bool
+	// 删除缓存时的配置项
+	delOpts []CacheOption
+}
+
+// 写流程. 具体流程由一致性策略决定，开启租约模式时则使用租约模式的写流程
+func (s *Service) write(ctx context.Context, op writeOp) error {
+	if s.opts.leaseMilis > 0 {
+		return s.writeWithLease(ctx, op.key, op.write, op.delOpts...)
+	}
+	return s.strategy.write(ctx, op)
+}
+
+// 读操作的附加信息
+type GetInfo struct {
+	// 是否使用到缓存
+	UseCache bool
+	// 是否为软过期后的过期数据. 仅在开启 WithStaleWhileRevalidate 时可能为 true
+	Stale bool
+	// 是否为加载数据失败后降级返回的影子副本. 仅在开启 WithFailStatic 时可能为 true
+	Degraded bool
+}
+
+// 2 读操作. 通过 opts 指定单次调用的配置项，例如 BypassCache、CacheOnly
+func (s *Service) Get(ctx context.Context, obj Object, opts ...GetOption) (useCache bool, err error) {
+	res, err := s.GetWithResult(ctx, obj, opts...)
+	return res.UseCache, err
+}
+
+// 读操作，返回读操作的附加信息
+func (s *Service) GetWithInfo(ctx context.Context, obj Object, opts ...GetOption) (GetInfo, error) {
+	res, err := s.GetWithResult(ctx, obj, opts...)
+	return res.GetInfo, err
+}
+
+// 读操作，返回读操作的详细结果
+func (s *Service) GetWithResult(ctx context.Context, obj Object, opts ...GetOption) (GetResult, error) {
+	return chainGet(s.opts.getInterceptors, func(ctx context.Context, obj Object) (GetResult, error) {
+		return s.getOrLoad(ctx, obj, s.dbLoader(obj), s.dbLoader)
+	})(withGetOptions(ctx, opts), obj)
+}
+
+// 读操作. 缓存 miss 时通过使用方提供的 loader 加载数据，适用于数据源不是 db 的场景
+// loader 需要将数据写入 obj 中，数据不存在时返回 ErrorDataNotExist 或 ErrorDBMiss
+// loader 与 obj 绑定，无法在后台刷新数据，因此不会返回软过期后的过期数据
+func (s *Service) GetOrLoad(ctx context.Context, obj Object, loader func(ctx context.Context) error, opts ...GetOption) (useCache bool, err error) {
+	res, err := chainGet(s.opts.getInterceptors, func(ctx context.Context, obj Object) (GetResult, error) {
+		return s.getOrLoad(ctx, obj, loader, nil)
+	})(withGetOptions(ctx, opts), obj)
+	return res.UseCache, err
+}
+
+// 读流程. newLoader 用于构造后台刷新过期数据时的 loader，为 nil 时不返回过期数据
+func (s *Service) getOrLoad(ctx context.Context, obj Object, loader func(ctx context.Context) error, newLoader func(obj Object) func(ctx context.Context) error) (GetResult, error) {
+	ctx, span := s.startSpan(ctx, "consistent_cache.Get", obj.Key())
+	ctx, recorder := withGetRecorder(ctx)
+
+	info, err := s.read(ctx, obj, loader, newLoader)
+	// 开启 fail-static 模式时，加载数据失败则降级读取影子副本. 调用方指定了跳过缓存或只读缓存时不降级
+	o := GetOptionsFromContext(ctx)
+	if err != nil && s.opts.shadowExpireSeconds > 0 && !errors.Is(err, ErrorDataNotExist) && !isContextErr(err) &&
+		!o.BypassCache && !o.ForceRefresh && !o.CacheOnly {
+		info, err = s.readShadow(ctx, obj, err)
+	}
+	s.observeGet(info, err)
+
+	span.SetAttributes(attrHit.Bool(info.UseCache), attrExists.Bool(!errors.Is(err, ErrorDataNotExist)),
+		attrStale.Bool(info.Stale), attrDegraded.Bool(info.Degraded))
+	endSpan(span, err)
+	return recorder.result(info, err), err
+}
+
+func (s *Service) read(ctx context.Context, obj Object, loader func(ctx context.Context) error, newLoader func(obj Object) func(ctx context.Context) error) (GetInfo, error) {
+	o := GetOptionsFromContext(ctx)
+	switch {
+	// 跳过缓存直接读 db，且不写缓存
+	case o.BypassCache:
+		return GetInfo{}, s.loadOnly(ctx, obj, loader)
+	// 跳过缓存直接读 db 并写缓存. 租约模式下由于未持有租约，不写缓存
+	case o.ForceRefresh && s.opts.leaseMilis > 0:
+		return GetInfo{}, s.loadOnly(ctx, obj, loader)
+	case o.ForceRefresh:
+		_, err := s.loadAndFill(ctx, obj, loader)
+		return GetInfo{}, err
+	case o.CacheOnly:
+		return s.readCacheOnly(ctx, obj)
+	}
+
+	if s.opts.leaseMilis > 0 {
+		useCache, err := s.getWithLease(ctx, obj, loader)
+		return GetInfo{UseCache: useCache}, err
+	}
+
 	// 1 读取缓存
-	v, err := s.cache.Get(ctx, obj.Key())
-	// 2 非缓存 miss 类错误，直接抛出错误
+	v, ttlMilis, err := s.getCache(ctx, obj.Key())
+	// 2 缓存不可用时直接读 db，且不写缓存
+	if errors.Is(err, ErrorCacheUnavailable) {
+		return GetInfo{}, s.loadOnly(ctx, obj, loader)
+	}
+	// 3 非缓存 miss 类错误，直接抛出错误
 	if err != nil && !errors.Is(err, ErrorCacheMiss) {
-		return false, err
+		return GetInfo{}, err
 	}
 
-	// 3 读取到缓存结果
+	// 4 读取到缓存结果
 	if err == nil {
-		// 3.1 读取到的数据为 EmptyData. 是为了防止缓存穿透而设置的空值
-		if v == NullData {
-			return true, ErrorDataNotExist
+		value, fresh := s.openEnvelope(v)
+		switch {
+		// 4.1 调用方不使用负缓存，NullData 视为缓存 miss
+		case o.NoNegativeCache && value == NullData:
+		// 4.2 未软过期的数据直接返回. 命中提前刷新时视为缓存 miss，由当前调用方提前加载数据并写缓存
+		case fresh && !s.shouldRefreshEarly(ttlMilis):
+			return GetInfo{UseCache: true}, readCache(obj, value)
+		// 4.3 数据已经软过期，在满足条件时返回过期数据并在后台刷新，否则视为缓存 miss
+		case !fresh && newLoader != nil && s.serveStale(ctx, obj, value, newLoader):
+			return GetInfo{UseCache: true, Stale: true}, readCache(obj, value)
+		}
+	}
+
+	// 5 缓存 miss，加载数据并写缓存
+	useCache, err := s.loadCoalesced(ctx, obj, loader)
+	return GetInfo{UseCache: useCache}, err
+}
+
+// 只读缓存，不读 db. 软过期的数据只在 key 处于启用状态时返回，且不触发后台刷新
+func (s *Service) readCacheOnly(ctx context.Context, obj Object) (GetInfo, error) {
+	v, _, err := s.getCache(ctx, obj.Key())
+	if err != nil {
+		return GetInfo{}, err
+	}
+	value, fresh := s.openEnvelope(v)
+	if value == NullData && GetOptionsFromContext(ctx).NoNegativeCache {
+		return GetInfo{}, ErrorCacheMiss
+	}
+	// 处于禁用状态的 key 有写流程正在进行，过期数据可能已经与 db 不一致，视为缓存 miss
+	if !fresh {
+		enabled, err := s.cache.IsEnabled(ctx, obj.Key())
+		if err != nil {
+			s.opts.logger.Errorf("check enable fail, key: %s, err: %v", obj.Key(), err)
+			return GetInfo{}, ErrorCacheMiss
+		}
+		if !enabled {
+			return GetInfo{}, ErrorCacheMiss
 		}
-		// 3.2 正常读取到数据
-		return true, obj.Read(v)
 	}
+	return GetInfo{UseCache: true, Stale: !fresh}, readCache(obj, value)
+}
 
-	// 4 缓存 miss，读 db
-	if err = s.db.Get(ctx, obj); err != nil && !errors.Is(err, ErrorDBMiss) {
+// 缓存 miss 时加载数据并写缓存. 开启缓存 miss 合并机制时，同一时刻相同 key 只由一个调用方加载
+func (s *Service) loadCoalesced(ctx context.Context, obj Object, loader func(ctx context.Context) error) (useCache bool, err error) {
+	if s.group == nil {
+		_, err = s.load(ctx, obj, loader)
 		return false, err
 	}
 
-	// 5 db 中也没有数据，则尝试往 cache 中写入 NullData
-	if errors.Is(err, ErrorDBMiss) {
-		if ok, err := s.cache.PutWhenEnable(ctx, obj.Key(), NullData, s.opts.CacheExpireSeconds()); err != nil {
+	// 开启了缓存 miss 合并机制，同一时刻相同 key 只由一个调用方加载数据并写缓存，其余调用方共享其结果
+	var loaded bool
+	v, err := s.group.Do(ctx, obj.Key(), func() (string, error) {
+		loaded = true
+		return s.load(ctx, obj, loader)
+	})
+	// 1 当前调用方亲自执行了加载操作，数据已经写入 obj
+	if loaded {
+		return false, err
+	}
+	// 2 执行加载操作的调用方因自身 ctx 终止而失败，而当前调用方 ctx 仍有效，则由当前调用方自行加载
+	if isContextErr(err) && ctx.Err() == nil {
+		_, err = s.load(ctx, obj, loader)
+		return false, err
+	}
+	if err != nil {
+		return false, err
+	}
+	// 3 共享其他调用方读取到的结果
+	return false, obj.Read(v)
+}
+
+// 缓存 miss 时的读取流程. 返回 obj 序列化后的结果
+func (s *Service) load(ctx context.Context, obj Object, loader func(ctx context.Context) error) (string, error) {
+	if s.opts.missLockExpireMilis <= 0 {
+		return s.loadAndFill(ctx, obj, loader)
+	}
+	return s.loadWithLock(ctx, obj, loader)
+}
+
+// 缓存 miss 时，加载数据并尝试写缓存. 返回 obj 序列化后的结果. fillOpts 为写缓存时的配置项
+func (s *Service) loadAndFill(ctx context.Context, obj Object, loader func(ctx context.Context) error, fillOpts ...CacheOption) (string, error) {
+	fillOpts = append(s.shadowOptions(), fillOpts...)
+
+	// 1 加载数据
+	err := s.runLoader(ctx, loader)
+	if err != nil && !errors.Is(err, ErrorDBMiss) && !errors.Is(err, ErrorDataNotExist) {
+		return "", err
+	}
+
+	// 2 数据不存在，则尝试往 cache 中写入 NullData. 调用方不使用负缓存时不写入
+	if err != nil {
+		if GetOptionsFromContext(ctx).NoNegativeCache {
+			return "", ErrorDataNotExist
+		}
+		value, expireSeconds := s.envelope(NullData)
+		if ok, err := s.fill(ctx, obj.Key(), value, expireSeconds, append([]CacheOption{WithNullData()}, fillOpts...)...); err != nil {
 			s.opts.logger.Errorf("put null data into cache fail, key: %s, err: %v", obj.Key(), err)
 		} else {
 			s.opts.logger.Infof("put null data into cache resp, key: %s, ok: %t", obj.Key(), ok)
 		}
 
-		return false, ErrorDataNotExist
+		return "", ErrorDataNotExist
 	}
 
-	// 6 成功获取到数据了，则需要将其写入缓存
-	v, err = obj.Write()
+	// 3 成功获取到数据了，则需要将其写入缓存
+	v, err := obj.Write()
 	if err != nil {
-		return false, err
+		return "", err
 	}
-	if ok, err := s.cache.PutWhenEnable(ctx, obj.Key(), v, s.opts.CacheExpireSeconds()); err != nil {
+	value, expireSeconds := s.envelope(v)
+	if ok, err := s.fill(ctx, obj.Key(), value, expireSeconds, append(s.versionOptions(obj), fillOpts...)...); err != nil {
 		s.opts.logger.Errorf("put data into cache fail, key: %s, data: %v, err: %v", obj.Key(), v, err)
 	} else {
 		s.opts.logger.Infof("put data into cache resp, key: %s, v: %v, ok: %t", obj.Key(), v, ok)
 	}
 
-	// 7 返回读取到的结果
-	return false, nil
+	// 4 返回读取到的结果
+	return v, nil
+}
+
+// 读流程写缓存
+func (s *Service) fill(ctx context.Context, key, value string, expireSeconds int64, opts ...CacheOption) (ok bool, err error) {
+	err = s.retry(ctx, func(ctx context.Context) (err error) {
+		ok, err = s.cache.PutWhenEnable(ctx, key, value, expireSeconds, opts...)
+		return err
+	})
+	if err == nil {
+		s.opts.metrics.ObserveFill(ok)
+		traceFill(ctx, ok)
+	}
+	getRecorderFrom(ctx).recordFill(ok, err)
+	return ok, err
+}
+
+// 执行 loader 加载数据，并记录加载耗时
+func (s *Service) runLoader(ctx context.Context, loader func(ctx context.Context) error) error {
+	start := time.Now()
+	err := loader(ctx)
+	cost := time.Since(start)
+	s.observeLoad(cost)
+	getRecorderFrom(ctx).recordLoad(cost)
+	return err
+}
+
+// 加载数据，但不写缓存
+func (s *Service) loadOnly(ctx context.Context, obj Object, loader func(ctx context.Context) error) error {
+	err := s.runLoader(ctx, loader)
+	if errors.Is(err, ErrorDBMiss) {
+		return ErrorDataNotExist
+	}
+	return err
+}
+
+// 以 db 作为数据源的 loader
+func (s *Service) dbLoader(obj Object) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return s.db.Get(ctx, obj)
+	}
+}
+
+// 处理读取到的缓存结果. v 需要已经去除信封
+func readCache(obj Object, v string) error {
+	// 读取到的数据为 NullData. 是为了防止缓存穿透而设置的空值
+	if v == NullData {
+		return ErrorDataNotExist
+	}
+	// 正常读取到数据
+	return obj.Read(v)
+}
+
+// 倘若 obj 带有版本号，写缓存时需要进行版本校验
+func (s *Service) versionOptions(obj Object) []CacheOption {
+	versioned, ok := obj.(Versioned)
+	if !ok {
+		return nil
+	}
+	return []CacheOption{WithVersion(versioned.Version())}
+}
+
+// 倘若 obj 带有版本号，写流程删除缓存时需要留下版本号墓碑
+func (s *Service) tombstoneOptions(obj Object) []CacheOption {
+	return s.versionTombstoneOptions(objectVersion(obj))
+}
+
+// 删除版本号为 version 的数据对应缓存时留下版本号墓碑. version 不大于 0 时不留下墓碑
+func (s *Service) versionTombstoneOptions(version int64) []CacheOption {
+	if version <= 0 {
+		return nil
+	}
+	return []CacheOption{WithVersion(version), WithTombstoneExpireSeconds(s.opts.versionTombstoneExpireSeconds)}
+}
+
+// 获取 obj 的版本号. 未实现 Versioned 接口时返回 0
+func objectVersion(obj Object) int64 {
+	if versioned, ok := obj.(Versioned); ok {
+		return versioned.Version()
+	}
+	return 0
+}
+
+// 异步延时撤销写流程 token 对 key 的禁用
+func (s *Service) enable(ctx context.Context, key, token string) {
+	go func() {
+		// 开启延时任务队列时，将写操作之前持久化的任务调整为在延时结束时到期. 由执行任务的 worker 开启链接到写流程的 span
+		if s.enqueue(enableTasks(ctx, []string{key}, token), s.opts.enableDelayMilis) {
+			return
+		}
+
+		ctx, span := s.startLinkedSpan(ctx, "consistent_cache.Enable", key)
+		// 每次执行的超时时间为 1 s，重试次数由重试策略决定
+		start := time.Now()
+		err := s.retry(ctx, func(ctx context.Context) error {
+			tctx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			return s.cache.Enable(tctx, key, token, s.opts.enableDelayMilis)
+		})
+		s.opts.metrics.ObserveEnable(time.Since(start), err)
+		endSpan(span, err)
+		if err != nil {
+			s.opts.logger.Errorf("enable fail, key: %s, err: %v", key, err)
+		}
+	}()
+}
+
+// 是否为 ctx 终止导致的错误
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
 }
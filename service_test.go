@@ -0,0 +1,102 @@
+package consistent_cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 不可用的缓存模块，记录写缓存的次数
+type unavailableCache struct {
+	Cache
+	puts int
+}
+
+func (c *unavailableCache) Get(ctx context.Context, key string) (string, error) {
+	return "", ErrorCacheUnavailable
+}
+
+func (c *unavailableCache) GetWithTTL(ctx context.Context, key string) (string, int64, error) {
+	v, err := c.Get(ctx, key)
+	return v, -1, err
+}
+
+func (c *unavailableCache) Disable(ctx context.Context, key, token string, expireSeconds int64) error {
+	return ErrorCacheUnavailable
+}
+
+func (c *unavailableCache) PutWhenEnable(ctx context.Context, key, value string, expireSeconds int64, opts ...CacheOption) (bool, error) {
+	c.puts++
+	return false, ErrorCacheUnavailable
+}
+
+// 验证点：1 缓存不可用时读操作直接读 db，且不写缓存 2 写操作在禁用缓存失败时拒绝写 db
+func Test_CacheUnavailable(t *testing.T) {
+	cache := &unavailableCache{}
+	service := newTestService(cache, &counterDB{count: "1"})
+	ctx := context.Background()
+
+	obj := &counterObject{K: "key"}
+	useCache, err := service.Get(ctx, obj)
+	assert.NoError(t, err)
+	assert.False(t, useCache)
+	assert.Equal(t, "1", obj.Count)
+	assert.Equal(t, 0, cache.puts)
+
+	err = service.Put(ctx, obj)
+	assert.ErrorIs(t, err, ErrorCacheUnavailable)
+}
+
+// 验证点：1 CacheOnly 缓存 miss 时不读 db，禁用状态下不返回软过期数据 2 BypassCache 读 db 且不写缓存 3 ForceRefresh 读 db 并写缓存 4 NoNegativeCache 不使用缓存中的 NullData
+func Test_GetOptions(t *testing.T) {
+	cache := newMemoryCache()
+	service := newTestService(cache, &counterDB{count: "2"})
+	ctx := context.Background()
+
+	cached := `{"k":"key","count":"1"}`
+	cache.set("key", cached)
+	obj := &counterObject{K: "key"}
+	useCache, err := service.Get(ctx, obj, CacheOnly())
+	assert.NoError(t, err)
+	assert.True(t, useCache)
+	assert.Equal(t, "1", obj.Count)
+
+	_, err = service.Get(ctx, &counterObject{K: "other"}, CacheOnly())
+	assert.ErrorIs(t, err, ErrorCacheMiss)
+	assert.Empty(t, cache.get("other"))
+
+	obj = &counterObject{K: "key"}
+	useCache, err = service.Get(ctx, obj, BypassCache())
+	assert.NoError(t, err)
+	assert.False(t, useCache)
+	assert.Equal(t, "2", obj.Count)
+	assert.Equal(t, cached, cache.get("key"))
+
+	obj = &counterObject{K: "key"}
+	_, err = service.Get(ctx, obj, ForceRefresh())
+	assert.NoError(t, err)
+	assert.Equal(t, "2", obj.Count)
+	assert.Equal(t, `{"k":"key","count":"2"}`, cache.get("key"))
+
+	// 只读缓存时，软过期的数据仅在 key 处于启用状态时返回
+	cache.set("stale", envelopePrefix+`1|{"k":"stale","count":"1"}`)
+	obj = &counterObject{K: "stale"}
+	info, err := service.GetWithInfo(ctx, obj, CacheOnly())
+	assert.NoError(t, err)
+	assert.Equal(t, GetInfo{UseCache: true, Stale: true}, info)
+	assert.Equal(t, "1", obj.Count)
+	cache.setEnabled(false)
+	_, err = service.GetWithInfo(ctx, &counterObject{K: "stale"}, CacheOnly())
+	assert.ErrorIs(t, err, ErrorCacheMiss)
+	cache.setEnabled(true)
+
+	cache.set("null", NullData)
+	_, err = service.Get(ctx, &counterObject{K: "null"}, CacheOnly(), NoNegativeCache())
+	assert.ErrorIs(t, err, ErrorCacheMiss)
+	obj = &counterObject{K: "null"}
+	useCache, err = service.Get(ctx, obj, NoNegativeCache())
+	assert.NoError(t, err)
+	assert.False(t, useCache)
+	assert.Equal(t, "2", obj.Count)
+}
@@ -0,0 +1,240 @@
+package consistent_cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/xiaoxuxiansheng/consistent_cache/lib/runtime"
+)
+
+// 一致性策略的类型
+type StrategyType int
+
+const (
+	// 禁用/启用读流程写缓存机制. 写流程：禁用读流程写缓存机制 -> 删除缓存 -> 写 db -> 延时启用读流程写缓存机制
+	StrategyDisableEnable StrategyType = iota
+	// 延时双删. 写流程：删除缓存 -> 写 db -> 延时再次删除缓存
+	// 不依赖 disable key，但在第二次删除之前，并发读流程可能将旧数据写回缓存
+	StrategyDoubleDelete
+)
+
+// 一致性策略，决定写流程中缓存失效的方式
+type strategy interface {
+	// 写流程
+	write(ctx context.Context, op writeOp) error
+	// 批量写流程. keys、versions 与 objs 一一对应，版本号大于 0 时删除缓存需要留下版本号墓碑
+	mwrite(ctx context.Context, objs []Object, keys []string, versions []int64) []error
+}
+
+func newStrategy(s *Service) strategy {
+	switch s.opts.strategyType {
+	case StrategyDoubleDelete:
+		return &doubleDeleteStrategy{s: s}
+	default:
+		return &disableEnableStrategy{s: s}
+	}
+}
+
+// 禁用/启用读流程写缓存机制的一致性策略
+type disableEnableStrategy struct {
+	s *Service
+}
+
+// 写流程：禁用读流程写缓存机制 -> 删除缓存 -> 执行写操作 -> (写穿缓存) -> 延时启用读流程写缓存机制
+func (d *disableEnableStrategy) write(ctx context.Context, op writeOp) error {
+	s := d.s
+
+	// 1 以当前写流程 token 的身份，针对 key 维度禁用读流程写缓存机制
+	// 同一 key 存在多个并发写流程时，只有最后一个写流程的延时启用结束后，读流程写缓存机制才会启用
+	token := runtime.GenerateUniqueID()
+	if err := s.step(ctx, PutStepDisable, func(ctx context.Context) error {
+		return s.cache.Disable(ctx, op.key, token, s.opts.disableExpireSeconds)
+	}); err != nil {
+		return err
+	}
+
+	s.prepareEnable(ctx, []string{op.key}, token)
+	defer s.enable(ctx, op.key, token)
+
+	// 2 删除 key 维度对应缓存
+	if err := s.step(ctx, PutStepDel, func(ctx context.Context) error {
+		return s.cache.Del(ctx, op.key, op.delOpts...)
+	}); err != nil {
+		return err
+	}
+
+	// 3 执行写操作
+	if op.write == nil {
+		return nil
+	}
+	if err := s.step(ctx, PutStepWrite, op.write); err != nil {
+		return err
+	}
+
+	// 4 开启写穿模式时，将写入 db 的数据写入缓存
+	if op.writeThrough {
+		d.writeThrough(ctx, op.obj, token)
+	}
+	return nil
+}
+
+// 以写流程 token 的身份将 obj 写入缓存. 只有在没有其他写流程与当前写流程并发时才会写入，否则保持缓存删除的状态
+// 写操作已经成功，因此写缓存失败只打印日志
+func (d *disableEnableStrategy) writeThrough(ctx context.Context, obj Object, token string) {
+	s := d.s
+	v, err := obj.Write()
+	if err != nil {
+		s.opts.logger.Errorf("write through marshal fail, key: %s, err: %v", obj.Key(), err)
+		return
+	}
+	value, expireSeconds := s.envelope(v)
+	var ok bool
+	if err := s.step(ctx, PutStepWriteThrough, func(ctx context.Context) (err error) {
+		ok, err = s.cache.PutWhenEnable(ctx, obj.Key(), value, expireSeconds, append(s.versionOptions(obj), WithWriterToken(token))...)
+		return err
+	}); err != nil {
+		s.opts.logger.Errorf("write through cache fail, key: %s, data: %v, err: %v", obj.Key(), v, err)
+	} else {
+		s.opts.logger.Infof("write through cache resp, key: %s, v: %v, ok: %t", obj.Key(), v, ok)
+	}
+}
+
+// 批量写流程：一次请求批量禁用读流程写缓存机制并删除缓存 -> 批量写 db -> 整批延时启用读流程写缓存机制
+func (d *disableEnableStrategy) mwrite(ctx context.Context, objs []Object, keys []string, versions []int64) []error {
+	s := d.s
+
+	// 1 通过一次请求，以当前写流程 token 的身份针对全部 key 禁用读流程写缓存机制，并删除对应缓存
+	token := runtime.GenerateUniqueID()
+	if err := s.step(ctx, PutStepDisable, func(ctx context.Context) error {
+		return s.cache.MDisableAndDel(ctx, keys, token, s.opts.disableExpireSeconds,
+			WithVersions(versions), WithTombstoneExpireSeconds(s.opts.versionTombstoneExpireSeconds))
+	}); err != nil {
+		return fillErrs(len(objs), err)
+	}
+
+	// 整批数据共用一次延时 enable 操作
+	s.prepareEnable(ctx, keys, token)
+	defer s.menable(ctx, keys, token)
+
+	// 2 数据批量写入 db
+	return s.mput(ctx, objs)
+}
+
+// 延时双删的一致性策略
+type doubleDeleteStrategy struct {
+	s *Service
+}
+
+// 写流程：删除缓存 -> 执行写操作 -> 延时再次删除缓存. 不支持写穿模式
+func (d *doubleDeleteStrategy) write(ctx context.Context, op writeOp) error {
+	s := d.s
+
+	// 1 删除 key 维度对应缓存
+	if err := s.step(ctx, PutStepDel, func(ctx context.Context) error {
+		return s.cache.Del(ctx, op.key, op.delOpts...)
+	}); err != nil {
+		return err
+	}
+
+	// 无论写操作是否成功，都需要延时再次删除缓存，清除并发读流程在写操作期间写回的旧数据
+	defer d.delayDel([]string{op.key}, []int64{d.delayMilis(op.obj)}, []int64{objectVersion(op.obj)})
+
+	// 2 执行写操作
+	if op.write == nil {
+		return nil
+	}
+	return s.step(ctx, PutStepWrite, op.write)
+}
+
+// 批量写流程：逐个删除缓存 -> 批量写 db -> 延时再次删除缓存
+func (d *doubleDeleteStrategy) mwrite(ctx context.Context, objs []Object, keys []string, versions []int64) []error {
+	s := d.s
+
+	// 1 逐个删除 key 对应缓存. 带有版本号的数据需要留下版本号墓碑
+	delays := make([]int64, 0, len(objs))
+	for i, obj := range objs {
+		delOpts := s.versionTombstoneOptions(versions[i])
+		if err := s.step(ctx, PutStepDel, func(ctx context.Context) error {
+			return s.cache.Del(ctx, keys[i], delOpts...)
+		}); err != nil {
+			return fillErrs(len(objs), err)
+		}
+		delays = append(delays, d.delayMilis(obj))
+	}
+
+	defer d.delayDel(keys, delays, versions)
+
+	// 2 数据批量写入 db
+	return s.mput(ctx, objs)
+}
+
+// 第二次删除缓存的延时时间. 优先使用 obj 通过 DoubleDeleteDelayer 接口指定的延时时间
+func (d *doubleDeleteStrategy) delayMilis(obj Object) int64 {
+	if delayer, ok := obj.(DoubleDeleteDelayer); ok && delayer.DoubleDeleteDelayMilis() > 0 {
+		return delayer.DoubleDeleteDelayMilis()
+	}
+	return d.s.opts.doubleDeleteDelayMilis
+}
+
+// 延时再次删除 keys 对应缓存，delays、versions 与 keys 一一对应. 带有版本号的数据再次删除时同样留下版本号墓碑，避免删除第一次删除留下的墓碑
+// 开启 WithDelayQueue 时，删除操作持久化到延时任务队列中执行；否则通过定时器异步执行，失败时按照重试策略重试
+// 第二次删除是清除旧数据的唯一手段，因此未设置重试策略时使用默认的指数退避策略
+func (d *doubleDeleteStrategy) delayDel(keys []string, delays []int64, versions []int64) {
+	s := d.s
+
+	// token 保证不同写流程的删除任务在延时任务队列中互不覆盖. 相同延时时间的 key 合并为一批
+	token := runtime.GenerateUniqueID()
+	groups := make(map[int64][]delayTask)
+	for i, key := range keys {
+		groups[delays[i]] = append(groups[delays[i]], delayTask{op: taskDel, token: token, version: versions[i], key: key})
+	}
+
+	for delayMilis, tasks := range groups {
+		if s.enqueue(tasks, delayMilis) {
+			continue
+		}
+
+		tasks := tasks
+		time.AfterFunc(time.Duration(delayMilis)*time.Millisecond, func() {
+			policy := s.opts.retryPolicy
+			if policy == nil {
+				policy = &ExponentialBackoff{}
+			}
+			for _, task := range tasks {
+				// 每次执行的超时时间为 1 s
+				err := s.retryWith(context.Background(), policy, func(ctx context.Context) error {
+					tctx, cancel := context.WithTimeout(ctx, time.Second)
+					defer cancel()
+					return s.cache.Del(tctx, task.key, s.versionTombstoneOptions(task.version)...)
+				})
+				if err != nil {
+					s.opts.logger.Errorf("delay del fail, key: %s, err: %v", task.key, err)
+				}
+			}
+		})
+	}
+}
+
+// 批量写 db，并上报写 db 步骤的耗时
+func (s *Service) mput(ctx context.Context, objs []Object) []error {
+	start := time.Now()
+	errs := s.db.MPut(ctx, objs)
+	var err error
+	for _, e := range errs {
+		if e != nil {
+			err = e
+			break
+		}
+	}
+	s.opts.metrics.ObservePutStep(PutStepWrite, time.Since(start), err)
+	return errs
+}
+
+// 构造与 objs 一一对应、全部为 err 的错误结果
+func fillErrs(n int, err error) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
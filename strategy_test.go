@@ -0,0 +1,144 @@
+package consistent_cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 只实现了 Del 方法的缓存模块，记录每次删除的 key. 前 failures 次删除失败，失败的删除不记录
+type delRecordCache struct {
+	Cache
+	mu       sync.Mutex
+	failures int
+	dels     []string
+}
+
+func (c *delRecordCache) Del(ctx context.Context, key string, opts ...CacheOption) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failures > 0 {
+		c.failures--
+		return errors.New("i/o timeout")
+	}
+	c.dels = append(c.dels, key)
+	return nil
+}
+
+func (c *delRecordCache) delCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.dels)
+}
+
+// 只实现了 Put 方法的数据库模块
+type putOnlyDB struct {
+	DB
+}
+
+func (putOnlyDB) Put(ctx context.Context, obj Object) error {
+	return nil
+}
+
+type strategyObject struct {
+	key        string
+	delayMilis int64
+}
+
+func (o *strategyObject) KeyColumn() string             { return "key" }
+func (o *strategyObject) Key() string                   { return o.key }
+func (o *strategyObject) Write() (string, error)        { return o.key, nil }
+func (o *strategyObject) Read(body string) error        { return nil }
+func (o *strategyObject) DoubleDeleteDelayMilis() int64 { return o.delayMilis }
+
+// 验证点：1 写流程先删除一次缓存，延时后再次删除 2 Object 可以单独指定延时时间
+func Test_DoubleDeleteStrategy(t *testing.T) {
+	cache := &delRecordCache{}
+	service := newTestService(cache, putOnlyDB{}, WithStrategy(StrategyDoubleDelete), WithDoubleDeleteDelayMilis(200))
+	ctx := context.Background()
+
+	// 使用默认的延时时间
+	assert.NoError(t, service.Put(ctx, &strategyObject{key: "key1"}))
+	assert.Equal(t, 1, cache.delCount())
+	// 单独指定延时时间
+	assert.NoError(t, service.Put(ctx, &strategyObject{key: "key2", delayMilis: 20}))
+	assert.Equal(t, 2, cache.delCount())
+
+	assert.Eventually(t, func() bool { return cache.delCount() == 3 }, time.Second, 5*time.Millisecond)
+	assert.Eventually(t, func() bool { return cache.delCount() == 4 }, time.Second, 5*time.Millisecond)
+	cache.mu.Lock()
+	assert.Equal(t, []string{"key1", "key2", "key2", "key1"}, cache.dels)
+	cache.mu.Unlock()
+}
+
+// 验证点：未开启延时任务队列时，第二次删除失败后重试
+func Test_DoubleDeleteStrategy_Retry(t *testing.T) {
+	cache := &delRecordCache{}
+	service := newTestService(cache, putOnlyDB{}, WithStrategy(StrategyDoubleDelete), WithDoubleDeleteDelayMilis(100))
+	ctx := context.Background()
+
+	assert.NoError(t, service.Put(ctx, &strategyObject{key: "key"}))
+	cache.mu.Lock()
+	cache.failures = 2
+	cache.mu.Unlock()
+	assert.Eventually(t, func() bool { return cache.delCount() == 2 }, time.Second, 5*time.Millisecond)
+}
+
+// 验证点：带有版本号的数据，延时双删的第二次删除同样留下版本号墓碑，包括由延时任务队列执行的第二次删除
+func Test_DoubleDeleteStrategy_Version(t *testing.T) {
+	for _, queue := range []DelayQueue{nil, &memoryDelayQueue{dues: make(map[string]time.Time)}} {
+		cache := newMemoryCache()
+		opts := []Option{WithStrategy(StrategyDoubleDelete), WithDoubleDeleteDelayMilis(20)}
+		if queue != nil {
+			opts = append(opts, WithDelayQueue(queue))
+		}
+		service := newTestService(cache, putOnlyDB{}, opts...)
+		ctx := context.Background()
+
+		assert.NoError(t, service.Put(ctx, &versionObject{strategyObject: strategyObject{key: "key"}, version: 3}))
+		assert.Eventually(t, func() bool { return len(cache.dels()) == 2 }, time.Second, 5*time.Millisecond)
+		for _, o := range cache.dels() {
+			assert.Equal(t, int64(3), o.Version)
+		}
+		service.Close()
+	}
+}
+
+// 记录写穿操作的缓存模块
+type writeThroughCache struct {
+	Cache
+	puts map[string]*CacheOptions
+}
+
+func (c *writeThroughCache) Disable(ctx context.Context, key, token string, expireSeconds int64) error {
+	return nil
+}
+
+func (c *writeThroughCache) Enable(ctx context.Context, key, token string, delayMilis int64) error {
+	return nil
+}
+
+func (c *writeThroughCache) Del(ctx context.Context, key string, opts ...CacheOption) error {
+	return nil
+}
+
+func (c *writeThroughCache) PutWhenEnable(ctx context.Context, key, value string, expireSeconds int64, opts ...CacheOption) (bool, error) {
+	c.puts[value] = NewCacheOptions(opts...)
+	return true, nil
+}
+
+// 验证点：开启写穿模式后，写 db 成功时以写流程 token 的身份写缓存；删除操作不写缓存
+func Test_WriteThrough(t *testing.T) {
+	cache := &writeThroughCache{puts: make(map[string]*CacheOptions)}
+	service := newTestService(cache, putOnlyDB{}, WithWriteThrough())
+	ctx := context.Background()
+
+	assert.NoError(t, service.Put(ctx, &strategyObject{key: "key"}))
+	assert.NoError(t, service.Invalidate(ctx, "key"))
+	assert.Len(t, cache.puts, 1)
+	assert.NotEmpty(t, cache.puts["key"].WriterToken)
+}
@@ -0,0 +1,105 @@
+package consistent_cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 缓存数据信封的前缀. 信封格式：{prefix}{软过期时间（毫秒时间戳）}|{value}
+const envelopePrefix = "CC_SWR|"
+
+// 构造写入缓存的数据及其过期时间. 开启 stale-while-revalidate 时，数据包装在带有软过期时间的信封中，过期时间延长 staleSeconds
+func (s *Service) envelope(v string) (string, int64) {
+	expireSeconds := s.opts.CacheExpireSeconds()
+	if s.opts.staleSeconds <= 0 {
+		return v, expireSeconds
+	}
+	softExpireMilis := time.Now().UnixMilli() + expireSeconds*1000
+	return envelopePrefix + strconv.FormatInt(softExpireMilis, 10) + "|" + v, expireSeconds + s.opts.staleSeconds
+}
+
+// 去除缓存数据的信封，返回数据以及是否仍未软过期. 不带信封的数据视为未软过期
+func (s *Service) openEnvelope(v string) (string, bool) {
+	if !strings.HasPrefix(v, envelopePrefix) {
+		return v, true
+	}
+	parts := strings.SplitN(strings.TrimPrefix(v, envelopePrefix), "|", 2)
+	if len(parts) != 2 {
+		return v, true
+	}
+	softExpireMilis, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return v, true
+	}
+	return parts[1], time.Now().UnixMilli() < softExpireMilis
+}
+
+// 判断能否返回软过期的数据，能够返回时在后台刷新数据
+// obj 无法复制，或者 key 处于禁用状态时，不返回过期数据
+func (s *Service) serveStale(ctx context.Context, obj Object, v string, newLoader func(obj Object) func(ctx context.Context) error) bool {
+	// 1 处于禁用状态的 key 有写流程正在进行，过期数据可能已经与 db 不一致
+	enabled, err := s.cache.IsEnabled(ctx, obj.Key())
+	if err != nil {
+		s.opts.logger.Errorf("check enable fail, key: %s, err: %v", obj.Key(), err)
+		return false
+	}
+	if !enabled {
+		return false
+	}
+
+	// 2 复制一份 obj 用于后台刷新，避免与调用方并发读写 obj
+	clone, ok := cloneObject(obj)
+	if !ok {
+		return false
+	}
+	// 以过期数据作为副本的初始内容，保证副本中的 key 等字段完整
+	if v != NullData {
+		if err := clone.Read(v); err != nil {
+			return false
+		}
+	}
+
+	// 3 同一进程内相同 key 只会同时存在一个后台刷新
+	s.mu.Lock()
+	if _, ok := s.refreshing[obj.Key()]; ok {
+		s.mu.Unlock()
+		return true
+	}
+	s.refreshing[obj.Key()] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.refreshing, clone.Key())
+			s.mu.Unlock()
+		}()
+
+		tctx, cancel := context.WithTimeout(context.Background(), DefaultStaleRefreshTimeoutMilis*time.Millisecond)
+		defer cancel()
+		if _, err := s.load(tctx, clone, newLoader(clone)); err != nil && !errors.Is(err, ErrorDataNotExist) {
+			s.opts.logger.Errorf("refresh stale data fail, key: %s, err: %v", clone.Key(), err)
+		}
+	}()
+	return true
+}
+
+// 复制 obj. 优先使用 Cloner 接口，否则对结构体指针进行浅拷贝
+func cloneObject(obj Object) (Object, bool) {
+	if cloner, ok := obj.(Cloner); ok {
+		return cloner.Clone(), true
+	}
+
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, false
+	}
+	clone := reflect.New(v.Elem().Type())
+	clone.Elem().Set(v.Elem())
+	obj, ok := clone.Interface().(Object)
+	return obj, ok
+}
@@ -0,0 +1,62 @@
+package consistent_cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_envelope(t *testing.T) {
+	service := &Service{opts: &Options{cacheExpireSeconds: 10, staleSeconds: 5}}
+	v, expireSeconds := service.envelope("value")
+	assert.Equal(t, int64(15), expireSeconds)
+	value, fresh := service.openEnvelope(v)
+	assert.Equal(t, "value", value)
+	assert.True(t, fresh)
+
+	value, fresh = service.openEnvelope(envelopePrefix + "1|value")
+	assert.Equal(t, "value", value)
+	assert.False(t, fresh)
+
+	// 不带信封的数据视为未软过期
+	value, fresh = service.openEnvelope("value")
+	assert.Equal(t, "value", value)
+	assert.True(t, fresh)
+}
+
+// 验证点：1 软过期的数据直接返回并标识为过期数据，同时在后台刷新 2 处于禁用状态的 key 不返回过期数据
+func Test_StaleWhileRevalidate(t *testing.T) {
+	cache := newMemoryCache()
+	db := &counterDB{count: "2"}
+	service := newTestService(cache, db, WithStaleWhileRevalidate(30))
+	ctx := context.Background()
+
+	cache.set("key", envelopePrefix+`1|{"k":"key","count":"1"}`)
+	obj := &counterObject{K: "key"}
+	info, err := service.GetWithInfo(ctx, obj)
+	assert.NoError(t, err)
+	assert.Equal(t, GetInfo{UseCache: true, Stale: true}, info)
+	assert.Equal(t, "1", obj.Count)
+
+	// 后台刷新后，缓存中为未软过期的新数据
+	assert.Eventually(t, func() bool {
+		value, fresh := service.openEnvelope(cache.get("key"))
+		return fresh && value == `{"k":"key","count":"2"}`
+	}, time.Second, 10*time.Millisecond)
+	info, err = service.GetWithInfo(ctx, obj)
+	assert.NoError(t, err)
+	assert.Equal(t, GetInfo{UseCache: true}, info)
+	assert.Equal(t, "2", obj.Count)
+
+	// 处于禁用状态时，软过期的数据视为缓存 miss
+	cache.set("key", envelopePrefix+`1|{"k":"key","count":"1"}`)
+	cache.setEnabled(false)
+	db.count = "3"
+	obj = &counterObject{K: "key"}
+	info, err = service.GetWithInfo(ctx, obj)
+	assert.NoError(t, err)
+	assert.Equal(t, GetInfo{}, info)
+	assert.Equal(t, "3", obj.Count)
+}
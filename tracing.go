@@ -0,0 +1,63 @@
+package consistent_cache
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/xiaoxuxiansheng/consistent_cache"
+
+// span 中使用的属性
+const (
+	attrKey      = attribute.Key("cache.key")
+	attrHit      = attribute.Key("cache.hit")
+	attrExists   = attribute.Key("cache.exists")
+	attrStale    = attribute.Key("cache.stale")
+	attrDegraded = attribute.Key("cache.degraded")
+	attrFill     = attribute.Key("cache.fill")
+)
+
+// 开启 key 维度的 span
+func (s *Service) startSpan(ctx context.Context, name, key string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return s.opts.tracer.Start(ctx, name, append(opts, trace.WithAttributes(attrKey.String(key)))...)
+}
+
+// 结束 span. 数据不存在不视为错误
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrorDataNotExist) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// 在 ctx 对应的 span 上记录读流程写缓存的结果
+func traceFill(ctx context.Context, accepted bool) {
+	result := "rejected"
+	if accepted {
+		result = "accepted"
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attrFill.String(result))
+}
+
+// 为后台的延时启用操作开启新的 span，并链接到触发该操作的写流程 span，避免丢失链路
+func (s *Service) startLinkedSpan(parent context.Context, name, key string) (context.Context, trace.Span) {
+	return s.startSpan(context.Background(), name, key, trace.WithLinks(trace.LinkFromContext(parent)))
+}
+
+// 将 ctx 中的 span 编码为 W3C traceparent，用于持久化的延时任务. ctx 中不存在有效的 span 时返回空串
+func traceparentFrom(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// 将 traceparent 解码为 span 并注入新的 ctx 中，供 startLinkedSpan 链接使用
+func contextWithTraceparent(traceparent string) context.Context {
+	return propagation.TraceContext{}.Extract(context.Background(), propagation.MapCarrier{"traceparent": traceparent})
+}
@@ -0,0 +1,98 @@
+package consistent_cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// 查找名称为 name 的 span
+func findSpan(spans tracetest.SpanStubs, name string) (tracetest.SpanStub, bool) {
+	for _, span := range spans {
+		if span.Name == name {
+			return span, true
+		}
+	}
+	return tracetest.SpanStub{}, false
+}
+
+// 验证点：1 读操作的 span 记录 key、是否命中以及写缓存结果，加载数据失败时记录错误 2 写操作的每个步骤为子 span 3 延时启用操作的 span 链接到写操作的 span
+// 4 开启延时任务队列时，由 worker 执行的启用操作的 span 同样链接到写操作的 span
+func Test_Tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	cache := newMemoryCache()
+	service := newTestService(cache, &counterDB{count: "1"}, WithTracerProvider(provider))
+	ctx := context.Background()
+
+	_, err := service.Get(ctx, &counterObject{K: "key"})
+	assert.NoError(t, err)
+	span, ok := findSpan(exporter.GetSpans(), "consistent_cache.Get")
+	assert.True(t, ok)
+	attrs := make(map[string]string)
+	for _, attr := range span.Attributes {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	assert.Equal(t, "key", attrs["cache.key"])
+	assert.Equal(t, "false", attrs["cache.hit"])
+	assert.Equal(t, "accepted", attrs["cache.fill"])
+
+	exporter.Reset()
+	service = newTestService(newMemoryCache(), unavailableDB{}, WithTracerProvider(provider))
+	_, err = service.Get(ctx, &counterObject{K: "key"})
+	assert.ErrorIs(t, err, errDBUnavailable)
+	span, ok = findSpan(exporter.GetSpans(), "consistent_cache.Get")
+	assert.True(t, ok)
+	assert.Equal(t, codes.Error, span.Status.Code)
+	assert.Len(t, span.Events, 1)
+
+	exporter.Reset()
+	writeCache := &writeThroughCache{puts: make(map[string]*CacheOptions)}
+	service = newTestService(writeCache, putOnlyDB{}, WithTracerProvider(provider))
+	assert.NoError(t, service.Put(ctx, &strategyObject{key: "key"}))
+	assert.Eventually(t, func() bool {
+		_, ok := findSpan(exporter.GetSpans(), "consistent_cache.Enable")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	spans := exporter.GetSpans()
+	put, _ := findSpan(spans, "consistent_cache.Put")
+	for _, name := range []string{"consistent_cache.disable", "consistent_cache.del", "consistent_cache.write"} {
+		step, ok := findSpan(spans, name)
+		assert.True(t, ok, name)
+		assert.Equal(t, put.SpanContext.SpanID(), step.Parent.SpanID(), name)
+	}
+	enable, _ := findSpan(spans, "consistent_cache.Enable")
+	assert.False(t, enable.Parent.IsValid())
+	assert.Len(t, enable.Links, 1)
+	assert.Equal(t, put.SpanContext.TraceID(), enable.Links[0].SpanContext.TraceID())
+
+	exporter.Reset()
+	queue := &memoryDelayQueue{dues: make(map[string]time.Time)}
+	service = newTestService(&flakyEnableCache{}, putOnlyDB{}, WithTracerProvider(provider), WithDelayQueue(queue),
+		WithEnableDelayMilis(50))
+	defer service.Close()
+	assert.NoError(t, service.Put(ctx, &strategyObject{key: "key"}))
+	assert.Eventually(t, func() bool {
+		_, ok := findSpan(exporter.GetSpans(), "consistent_cache.Enable")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	spans = exporter.GetSpans()
+	put, _ = findSpan(spans, "consistent_cache.Put")
+	enable, _ = findSpan(spans, "consistent_cache.Enable")
+	assert.False(t, enable.Parent.IsValid())
+	assert.Len(t, enable.Links, 1)
+	assert.Equal(t, put.SpanContext.TraceID(), enable.Links[0].SpanContext.TraceID())
+	assert.Equal(t, put.SpanContext.SpanID(), enable.Links[0].SpanContext.SpanID())
+	// 写操作前后入队的是同一个任务，执行后队列为空
+	assert.Eventually(t, func() bool {
+		pending, err := service.Pending(ctx)
+		return err == nil && pending == 0
+	}, time.Second, 10*time.Millisecond)
+}
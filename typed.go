@@ -0,0 +1,227 @@
+package consistent_cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/cast"
+)
+
+// 结构体字段中用于标识 key 的 tag. 例如：`cc:"key"`
+const KeyTag = "cc"
+
+// 序列化/反序列化模块
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// json 实现版本的序列化模块
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// 泛型版本的一致性缓存服务. 基于 Service 实现，一致性保证与 Service 完全相同
+// T 需要为结构体类型，并通过 `cc:"key"` tag 标识 key 对应的字段
+type TypedService[T any] struct {
+	service *Service
+	codec   Codec
+	// key 对应字段在结构体中的位置
+	keyIndex []int
+	// key 对应的字段名
+	keyColumn string
+	// *T 是否实现了 Versioned 接口
+	versioned bool
+}
+
+type TypedOption func(*typedOptions)
+
+type typedOptions struct {
+	codec Codec
+}
+
+// 指定序列化模块，默认使用 json
+func WithCodec(codec Codec) TypedOption {
+	return func(o *typedOptions) {
+		o.codec = codec
+	}
+}
+
+// 构造泛型版本的一致性缓存服务. 倘若 T 不是结构体或者未通过 tag 标识 key 对应的字段，则会 panic
+func NewTypedService[T any](service *Service, opts ...TypedOption) *TypedService[T] {
+	o := typedOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.codec == nil {
+		o.codec = JSONCodec{}
+	}
+
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if typ.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("typed service requires struct type, got: %s", typ))
+	}
+	field, ok := keyField(typ)
+	if !ok {
+		panic(fmt.Sprintf("typed service requires a field tagged with `%s:\"key\"` in %s", KeyTag, typ))
+	}
+
+	_, versioned := any((*T)(nil)).(Versioned)
+	return &TypedService[T]{
+		service:   service,
+		codec:     o.codec,
+		keyIndex:  field.Index,
+		keyColumn: columnName(field),
+		versioned: versioned,
+	}
+}
+
+// 读操作
+func (t *TypedService[T]) Get(ctx context.Context, key string, opts ...GetOption) (T, GetInfo, error) {
+	var val T
+	obj := t.newObject(&val, key)
+	info, err := t.service.GetWithInfo(ctx, obj, opts...)
+	return val, info, err
+}
+
+// 写操作
+func (t *TypedService[T]) Put(ctx context.Context, val T) error {
+	key, err := cast.ToStringE(reflect.ValueOf(val).FieldByIndex(t.keyIndex).Interface())
+	if err != nil {
+		return err
+	}
+	return t.service.Put(ctx, t.newObject(&val, key))
+}
+
+// 将 T 包装成 Object. *T 实现了 Versioned 接口时，包装后的 Object 同样实现 Versioned 接口
+func (t *TypedService[T]) newObject(val *T, key string) Object {
+	obj := &typedObject[T]{
+		val:       val,
+		key:       key,
+		keyColumn: t.keyColumn,
+		codec:     t.codec,
+	}
+	if t.versioned {
+		return &versionedTypedObject[T]{typedObject: obj}
+	}
+	return obj
+}
+
+// 将 T 包装成 Object. *T 实现了 DoubleDeleteDelayer 接口时透传第二次删除缓存的延时时间
+type typedObject[T any] struct {
+	val       *T
+	key       string
+	keyColumn string
+	codec     Codec
+}
+
+// 获取 key 对应的字段名
+func (o *typedObject[T]) KeyColumn() string {
+	return o.keyColumn
+}
+
+// 获取 key 对应的值
+func (o *typedObject[T]) Key() string {
+	return o.key
+}
+
+// 将 object 序列化成字符串
+func (o *typedObject[T]) Write() (string, error) {
+	body, err := o.codec.Marshal(o.val)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// 读取字符串内容，反序列化到 object 实例中
+func (o *typedObject[T]) Read(body string) error {
+	return o.codec.Unmarshal([]byte(body), o.val)
+}
+
+// 复制 object，供后台刷新过期数据时使用
+func (o *typedObject[T]) Clone() Object {
+	val := *o.val
+	clone := *o
+	clone.val = &val
+	return &clone
+}
+
+// 获取实际的数据模型，供数据库模块使用
+func (o *typedObject[T]) Model() interface{} {
+	return o.val
+}
+
+// 第二次删除缓存的延时时间. *T 未实现 DoubleDeleteDelayer 接口时返回 0，使用 Service 的默认延时时间
+func (o *typedObject[T]) DoubleDeleteDelayMilis() int64 {
+	if delayer, ok := any(o.val).(DoubleDeleteDelayer); ok {
+		return delayer.DoubleDeleteDelayMilis()
+	}
+	return 0
+}
+
+// *T 实现了 Versioned 接口时使用的包装，透传数据的版本号
+// 未实现 Versioned 接口的 T 不能使用该包装，否则会被视为带有版本号的数据
+type versionedTypedObject[T any] struct {
+	*typedObject[T]
+}
+
+// 获取数据的版本号
+func (o *versionedTypedObject[T]) Version() int64 {
+	return any(o.val).(Versioned).Version()
+}
+
+// 复制 object，复制结果同样实现 Versioned 接口
+func (o *versionedTypedObject[T]) Clone() Object {
+	return &versionedTypedObject[T]{typedObject: o.typedObject.Clone().(*typedObject[T])}
+}
+
+// 查找通过 tag 标识为 key 的字段
+func keyField(typ reflect.Type) (reflect.StructField, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		for _, tag := range strings.Split(field.Tag.Get(KeyTag), ",") {
+			if strings.TrimSpace(tag) == "key" {
+				return field, true
+			}
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// 获取字段对应的列名. 优先使用 gorm tag 中声明的 column，否则将字段名转为蛇形命名
+func columnName(field reflect.StructField) string {
+	for _, setting := range strings.Split(field.Tag.Get("gorm"), ";") {
+		kv := strings.SplitN(setting, ":", 2)
+		if len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "column") {
+			return strings.TrimSpace(kv[1])
+		}
+	}
+	return toSnakeCase(field.Name)
+}
+
+// 驼峰命名转为蛇形命名，连续的大写字母视为一个单词. 例如：UserID -> user_id
+func toSnakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,108 @@
+package consistent_cache
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_toSnakeCase(t *testing.T) {
+	assert.Equal(t, "key", toSnakeCase("Key"))
+	assert.Equal(t, "user_id", toSnakeCase("UserID"))
+	assert.Equal(t, "http_server", toSnakeCase("HTTPServer"))
+	assert.Equal(t, "created_at", toSnakeCase("CreatedAt"))
+}
+
+func Test_keyField(t *testing.T) {
+	type record struct {
+		ID      uint
+		BizKey  string `cc:"key"`
+		Content string
+	}
+	field, ok := keyField(reflect.TypeOf(record{}))
+	assert.True(t, ok)
+	assert.Equal(t, "biz_key", columnName(field))
+
+	type gormRecord struct {
+		Key_ string `gorm:"column:key" cc:"key"`
+	}
+	field, ok = keyField(reflect.TypeOf(gormRecord{}))
+	assert.True(t, ok)
+	assert.Equal(t, "key", columnName(field))
+
+	type noKey struct {
+		Key string
+	}
+	_, ok = keyField(reflect.TypeOf(noKey{}))
+	assert.False(t, ok)
+}
+
+type typedCounter struct {
+	K     string `json:"k" cc:"key"`
+	Count string `json:"count"`
+}
+
+// 带有版本号并单独指定第二次删除缓存延时时间的数据
+type versionedCounter struct {
+	K       string `json:"k" cc:"key"`
+	Count   string `json:"count"`
+	Ver     int64  `json:"-"`
+	DelayMs int64  `json:"-"`
+}
+
+func (c *versionedCounter) Version() int64                { return c.Ver }
+func (c *versionedCounter) DoubleDeleteDelayMilis() int64 { return c.DelayMs }
+
+// 验证点：1 缓存 miss 时读 db 并写缓存，再次读取命中缓存 2 写操作更新 db 并删除缓存 3 db 中数据不存在时返回 ErrorDataNotExist
+func Test_TypedService(t *testing.T) {
+	cache := newMemoryCache()
+	db := &counterDB{count: "1"}
+	service := NewTypedService[typedCounter](newTestService(cache, db))
+	ctx := context.Background()
+
+	val, info, err := service.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.False(t, info.UseCache)
+	assert.Equal(t, typedCounter{K: "key", Count: "1"}, val)
+	val, info, err = service.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.True(t, info.UseCache)
+	assert.Equal(t, typedCounter{K: "key", Count: "1"}, val)
+
+	assert.NoError(t, service.Put(ctx, typedCounter{K: "key", Count: "2"}))
+	assert.Empty(t, cache.get("key"))
+	val, _, err = service.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", val.Count)
+
+	// 未实现 Versioned 接口的 T 不会被视为带有版本号的数据
+	assert.Zero(t, cache.dels()[0].Version)
+	_, ok := service.newObject(&val, "key").(Versioned)
+	assert.False(t, ok)
+
+	service = NewTypedService[typedCounter](newTestService(newMemoryCache(), &counterDB{}))
+	_, _, err = service.Get(ctx, "other")
+	assert.ErrorIs(t, err, ErrorDataNotExist)
+}
+
+// 验证点：T 实现的 Versioned 与 DoubleDeleteDelayer 接口透传给 Service：删除缓存时留下版本号墓碑，第二次删除使用 T 指定的延时时间
+func Test_TypedService_Versioned(t *testing.T) {
+	cache := newMemoryCache()
+	service := NewTypedService[versionedCounter](newTestService(cache, &counterDB{count: "1"},
+		WithStrategy(StrategyDoubleDelete), WithDoubleDeleteDelayMilis(10000)))
+	ctx := context.Background()
+
+	assert.NoError(t, service.Put(ctx, versionedCounter{K: "key", Count: "2", Ver: 3, DelayMs: 20}))
+	assert.Eventually(t, func() bool { return len(cache.dels()) == 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int64(3), cache.dels()[0].Version)
+
+	val := versionedCounter{K: "key"}
+	obj, ok := service.newObject(&val, "key").(Versioned)
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), obj.Version())
+	_, ok = obj.(Cloner).Clone().(Versioned)
+	assert.True(t, ok)
+}
@@ -0,0 +1,78 @@
+package consistent_cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 记录删除缓存与写缓存时配置项的缓存模块，缓存始终 miss
+type versionRecordCache struct {
+	Cache
+	mu       sync.Mutex
+	delOpts  *CacheOptions
+	fillOpts *CacheOptions
+}
+
+func (c *versionRecordCache) GetWithTTL(ctx context.Context, key string) (string, int64, error) {
+	return "", -1, ErrorCacheMiss
+}
+
+func (c *versionRecordCache) Disable(ctx context.Context, key, token string, expireSeconds int64) error {
+	return nil
+}
+
+func (c *versionRecordCache) Enable(ctx context.Context, key, token string, delayMilis int64) error {
+	return nil
+}
+
+func (c *versionRecordCache) Del(ctx context.Context, key string, opts ...CacheOption) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delOpts = NewCacheOptions(opts...)
+	return nil
+}
+
+func (c *versionRecordCache) PutWhenEnable(ctx context.Context, key, value string, expireSeconds int64, opts ...CacheOption) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fillOpts = NewCacheOptions(opts...)
+	return true, nil
+}
+
+// 数据不存在的数据库模块
+type emptyDB struct {
+	DB
+}
+
+func (emptyDB) Get(ctx context.Context, obj Object) error {
+	return ErrorDBMiss
+}
+
+func (emptyDB) Delete(ctx context.Context, obj Object) error {
+	return nil
+}
+
+type versionObject struct {
+	strategyObject
+	version int64
+}
+
+func (o *versionObject) Version() int64 { return o.version }
+
+// 验证点：1 Del 带有版本号的数据时留下版本号墓碑 2 数据不存在时以 NullData 的身份写缓存，由缓存模块校验版本号墓碑
+func Test_Version_NullData(t *testing.T) {
+	cache := &versionRecordCache{}
+	service := newTestService(cache, emptyDB{}, WithVersionTombstoneExpireSeconds(30))
+	ctx := context.Background()
+
+	assert.NoError(t, service.Del(ctx, &versionObject{strategyObject: strategyObject{key: "key"}, version: 3}))
+	assert.Equal(t, int64(3), cache.delOpts.Version)
+	assert.Equal(t, int64(30), cache.delOpts.TombstoneExpireSeconds)
+
+	_, err := service.Get(ctx, &versionObject{strategyObject: strategyObject{key: "key"}})
+	assert.ErrorIs(t, err, ErrorDataNotExist)
+	assert.True(t, cache.fillOpts.NullData)
+}
@@ -0,0 +1,259 @@
+package consistent_cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/xiaoxuxiansheng/consistent_cache/lib/runtime"
+)
+
+// 写回锁对应的 key. 同一时刻只有一个 Service 实例执行写回，保证同一 key 的变更按照追加顺序写入 db
+const writeBehindLockKey = "Write_Behind_Flush"
+
+// 写回模式下的写流程：变更追加到缓冲区 -> 禁用读流程写缓存 -> 写缓存. 变更由后台协程写入 db 后撤销禁用
+func (s *Service) putBehind(ctx context.Context, obj Object) error {
+	if _, ok := obj.(Versioned); ok {
+		return errors.New("write behind mode doesn't support versioned object")
+	}
+	v, err := obj.Write()
+	if err != nil {
+		return err
+	}
+
+	// 1 变更追加到缓冲区. 缓冲区已满时通知写回协程立即写回，并将错误抛给调用方
+	seq, err := s.opts.writeBuffer.Append(ctx, obj.Key(), v)
+	if errors.Is(err, ErrorWriteBufferFull) {
+		s.notifyFlush()
+	}
+	if err != nil {
+		return err
+	}
+
+	// 2 以追加序号作为写流程 token 禁用读流程写缓存，直到变更写入 db. 避免读流程将 db 中的旧数据写入缓存，覆盖缓冲区中尚未写入 db 的变更
+	if err := s.retry(ctx, func(ctx context.Context) error {
+		return s.cache.Disable(ctx, obj.Key(), writeBehindToken(seq), DefaultWriteBehindDisableSeconds)
+	}); err != nil {
+		// 变更已经持久化，禁用失败时只打印日志
+		s.opts.logger.Errorf("disable cache with write behind fail, key: %s, err: %v", obj.Key(), err)
+	}
+
+	// 3 以追加序号作为版本号写缓存，保证并发写操作之间，缓存中的数据与缓冲区中最后追加的变更一致
+	value, expireSeconds := s.envelope(v)
+	if _, err := s.cache.Set(ctx, obj.Key(), value, expireSeconds, WithVersion(seq)); err != nil {
+		// 变更已经持久化，写缓存失败时删除缓存，由读流程重新写缓存
+		s.opts.logger.Errorf("set cache with write behind fail, key: %s, err: %v", obj.Key(), err)
+		if err := s.cache.Del(ctx, obj.Key()); err != nil {
+			s.opts.logger.Errorf("del cache with write behind fail, key: %s, err: %v", obj.Key(), err)
+		}
+	}
+	return nil
+}
+
+// 追加序号对应的写流程 token
+func writeBehindToken(seq int64) string {
+	return fmt.Sprintf("write_behind_%d", seq)
+}
+
+// 将缓冲区中的变更全部写入 db. 直到缓冲区为空、ctx 终止或者变更写入 db 失败时返回
+func (s *Service) Flush(ctx context.Context) error {
+	if s.opts.writeBuffer == nil {
+		return nil
+	}
+
+	for {
+		read, acked, err := s.flushOnce(ctx)
+		if err != nil && !errors.Is(err, errFlushLocked) {
+			return err
+		}
+		// 缓冲区已经清空
+		if err == nil && read == 0 {
+			return nil
+		}
+		// 本轮读取到的变更全部写入 db 失败，避免死循环
+		if err == nil && acked == 0 {
+			return fmt.Errorf("flush write buffer stalled, %d entries not flushed", read)
+		}
+
+		// 写回锁被其他实例持有时，等待其写回完成
+		if errors.Is(err, errFlushLocked) {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(DefaultWriteBehindFlushMilis * time.Millisecond):
+			}
+		}
+	}
+}
+
+// 写回锁被其他 Service 实例持有
+var errFlushLocked = errors.New("write behind flush locked")
+
+// 写回过程中丢失了写回锁，例如续期失败
+var errFlushLockLost = errors.New("write behind flush lock lost")
+
+// 持续将缓冲区中的变更写入 db
+func (s *Service) runWriteBehind(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(DefaultWriteBehindFlushMilis * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.flushc:
+		}
+
+		for {
+			read, _, err := s.flushOnce(ctx)
+			if err != nil && !errors.Is(err, errFlushLocked) && ctx.Err() == nil {
+				s.opts.logger.Errorf("flush write buffer fail, err: %v", err)
+			}
+			// 一次读满一批时，说明缓冲区中可能还有积压的变更，继续写回
+			if err != nil || read < DefaultWriteBehindBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// 通知写回协程立即执行一次写回
+func (s *Service) notifyFlush() {
+	select {
+	case s.flushc <- struct{}{}:
+	default:
+	}
+}
+
+// 在后台定期为 token 持有的分布式锁续期. 续期失败或者锁被其他调用方持有时，终止返回的 ctx
+// 调用方需要调用返回的 stop 函数停止续期
+func (s *Service) keepLock(ctx context.Context, key, token string, expireMilis int64) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(time.Duration(expireMilis/3) * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			locked, err := s.cache.Lock(ctx, key, token, expireMilis)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil || !locked {
+				s.opts.logger.Errorf("renew lock fail, key: %s, locked: %t, err: %v", key, locked, err)
+				cancel()
+				return
+			}
+		}
+	}()
+	return ctx, func() {
+		cancel()
+		<-done
+	}
+}
+
+// 在写回锁的保护下，从缓冲区中读取一批变更，按 key 合并后批量写入 db，并确认写入成功的变更
+// 返回读取到的变更数量和确认的变更数量
+func (s *Service) flushOnce(ctx context.Context) (read, acked int, err error) {
+	buffer := s.opts.writeBuffer
+
+	// 1 抢写回锁
+	token := runtime.GenerateUniqueID()
+	locked, err := s.cache.Lock(ctx, writeBehindLockKey, token, DefaultWriteBehindLockMilis)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !locked {
+		return 0, 0, errFlushLocked
+	}
+	defer func() {
+		if err := s.cache.Unlock(context.Background(), writeBehindLockKey, token); err != nil {
+			s.opts.logger.Errorf("unlock write behind fail, err: %v", err)
+		}
+	}()
+	// 写回期间在后台为写回锁续期，续期失败时终止写回，避免与其他实例并发写回
+	lctx, stop := s.keepLock(ctx, writeBehindLockKey, token, DefaultWriteBehindLockMilis)
+	defer stop()
+
+	// 2 按照追加顺序读取一批变更
+	entries, err := buffer.Read(ctx, DefaultWriteBehindBatchSize)
+	if err != nil || len(entries) == 0 {
+		return 0, 0, err
+	}
+
+	// 3 按 key 合并，每个 key 只保留最后一笔变更
+	keys := make([]string, 0, len(entries))
+	values := make(map[string]string, len(entries))
+	ids := make(map[string][]string, len(entries))
+	seqs := make(map[string][]int64, len(entries))
+	for _, entry := range entries {
+		if _, ok := values[entry.Key]; !ok {
+			keys = append(keys, entry.Key)
+		}
+		values[entry.Key] = entry.Value
+		ids[entry.Key] = append(ids[entry.Key], entry.ID)
+		seqs[entry.Key] = append(seqs[entry.Key], entry.Seq)
+	}
+
+	acks := make([]string, 0, len(entries))
+	ackKeys := make([]string, 0, len(keys))
+	objs := make([]Object, 0, len(keys))
+	objKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		obj := s.opts.newObject()
+		if err := obj.Read(values[key]); err != nil {
+			// 无法解析的变更重试也无济于事，直接丢弃
+			s.opts.logger.Errorf("drop write buffer entry, key: %s, value: %s, err: %v", key, values[key], err)
+			acks = append(acks, ids[key]...)
+			ackKeys = append(ackKeys, key)
+			continue
+		}
+		objs = append(objs, obj)
+		objKeys = append(objKeys, key)
+	}
+
+	// 4 批量写入 db. 写入失败的变更保留在缓冲区中，下一轮重试
+	var firstErr error
+	if len(objs) > 0 {
+		for i, err := range s.db.MPut(lctx, objs) {
+			if err != nil {
+				s.opts.logger.Errorf("write behind put db fail, key: %s, err: %v", objKeys[i], err)
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			acks = append(acks, ids[objKeys[i]]...)
+			ackKeys = append(ackKeys, objKeys[i])
+		}
+	}
+
+	// 5 确认写入成功的变更. 确认之前校验仍持有写回锁，否则变更可能已经由其他实例写回
+	// 确认失败时变更会被重复写入 db，由于只保留每个 key 的最后一笔变更，重复写入不影响正确性
+	if lctx.Err() != nil && ctx.Err() == nil {
+		return len(entries), 0, errFlushLockLost
+	}
+	if err := buffer.Ack(ctx, acks...); err != nil {
+		return len(entries), 0, err
+	}
+
+	// 6 撤销已确认的变更对读流程写缓存的禁用
+	for _, key := range ackKeys {
+		for _, seq := range seqs[key] {
+			s.enable(ctx, key, writeBehindToken(seq))
+		}
+	}
+	if len(acks) == 0 {
+		return len(entries), 0, firstErr
+	}
+	return len(entries), len(acks), nil
+}
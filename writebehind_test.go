@@ -0,0 +1,344 @@
+package consistent_cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 内存实现的写回缓冲区
+type memoryWriteBuffer struct {
+	mu      sync.Mutex
+	maxLen  int
+	seq     int64
+	entries []BufferEntry
+}
+
+func (b *memoryWriteBuffer) Append(ctx context.Context, key, value string) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.entries) >= b.maxLen {
+		return 0, ErrorWriteBufferFull
+	}
+	b.seq++
+	b.entries = append(b.entries, BufferEntry{ID: fmt.Sprint(b.seq), Seq: b.seq, Key: key, Value: value})
+	return b.seq, nil
+}
+
+func (b *memoryWriteBuffer) Read(ctx context.Context, limit int) ([]BufferEntry, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if limit > len(b.entries) {
+		limit = len(b.entries)
+	}
+	return append([]BufferEntry(nil), b.entries[:limit]...), nil
+}
+
+func (b *memoryWriteBuffer) Ack(ctx context.Context, ids ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	acked := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		acked[id] = true
+	}
+	entries := b.entries[:0]
+	for _, entry := range b.entries {
+		if !acked[entry.ID] {
+			entries = append(entries, entry)
+		}
+	}
+	b.entries = entries
+	return nil
+}
+
+func (b *memoryWriteBuffer) Len(ctx context.Context) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int64(len(b.entries)), nil
+}
+
+// 只实现了写回模式所需方法的缓存模块，记录每个 key 处于禁用期内的写流程 token
+type writeBehindCache struct {
+	Cache
+	mu       sync.Mutex
+	values   map[string]string
+	disables map[string]map[string]bool
+}
+
+func newWriteBehindCache() *writeBehindCache {
+	return &writeBehindCache{values: make(map[string]string), disables: make(map[string]map[string]bool)}
+}
+
+func (c *writeBehindCache) Disable(ctx context.Context, key, token string, expireSeconds int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.disables[key] == nil {
+		c.disables[key] = make(map[string]bool)
+	}
+	c.disables[key][token] = true
+	return nil
+}
+
+func (c *writeBehindCache) Enable(ctx context.Context, key, token string, delayMilis int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.disables[key], token)
+	return nil
+}
+
+func (c *writeBehindCache) disabled(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.disables[key]) > 0
+}
+
+func (c *writeBehindCache) get(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *writeBehindCache) Get(ctx context.Context, key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.values[key]
+	if !ok {
+		return "", ErrorCacheMiss
+	}
+	return v, nil
+}
+
+func (c *writeBehindCache) GetWithTTL(ctx context.Context, key string) (string, int64, error) {
+	v, err := c.Get(ctx, key)
+	return v, -1, err
+}
+
+func (c *writeBehindCache) PutWhenEnable(ctx context.Context, key, value string, expireSeconds int64, opts ...CacheOption) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.disables[key]) > 0 {
+		return false, nil
+	}
+	c.values[key] = value
+	return true, nil
+}
+
+func (c *writeBehindCache) Set(ctx context.Context, key, value string, expireSeconds int64, opts ...CacheOption) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] = value
+	return true, nil
+}
+
+func (c *writeBehindCache) Del(ctx context.Context, key string, opts ...CacheOption) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.values, key)
+	return nil
+}
+
+func (c *writeBehindCache) MDisableAndDel(ctx context.Context, keys []string, token string, expireSeconds int64, opts ...CacheOption) error {
+	for _, key := range keys {
+		_ = c.Disable(ctx, key, token, expireSeconds)
+		_ = c.Del(ctx, key)
+	}
+	return nil
+}
+
+func (c *writeBehindCache) MEnable(ctx context.Context, keys []string, token string, delayMilis int64) error {
+	for _, key := range keys {
+		_ = c.Enable(ctx, key, token, delayMilis)
+	}
+	return nil
+}
+
+func (c *writeBehindCache) Lock(ctx context.Context, key, token string, expireMilis int64) (bool, error) {
+	return true, nil
+}
+
+func (c *writeBehindCache) Unlock(ctx context.Context, key, token string) error {
+	return nil
+}
+
+// 记录批量写入数据的数据库模块
+type mputRecordDB struct {
+	DB
+	mu   sync.Mutex
+	puts []map[string]string
+}
+
+// 读取最后一次写入的数据，未写入过的数据 count 为 0
+func (d *mputRecordDB) Get(ctx context.Context, obj Object) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	count := "0"
+	for _, batch := range d.puts {
+		if v, ok := batch[obj.Key()]; ok {
+			count = v
+		}
+	}
+	obj.(*counterObject).Count = count
+	return nil
+}
+
+func (d *mputRecordDB) MPut(ctx context.Context, objs []Object) []error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	batch := make(map[string]string, len(objs))
+	for _, obj := range objs {
+		batch[obj.Key()] = obj.(*counterObject).Count
+	}
+	d.puts = append(d.puts, batch)
+	return make([]error, len(objs))
+}
+
+// 验证点：1 Put 立即写缓存 2 Flush 按 key 合并变更后批量写入 db 3 缓冲区已满时 Put 返回 ErrorWriteBufferFull
+func Test_WriteBehind(t *testing.T) {
+	buffer := &memoryWriteBuffer{maxLen: 3}
+	cache := newWriteBehindCache()
+	db := &mputRecordDB{}
+	service := newTestService(cache, db, WithWriteBehind(buffer, func() Object { return &counterObject{} }))
+	defer service.Close()
+	// 停止后台写回，由测试手动执行 Flush
+	service.cancel()
+	service.wg.Wait()
+	ctx := context.Background()
+
+	assert.NoError(t, service.Put(ctx, &counterObject{K: "a", Count: "1"}))
+	assert.NoError(t, service.Put(ctx, &counterObject{K: "b", Count: "1"}))
+	assert.NoError(t, service.Put(ctx, &counterObject{K: "a", Count: "2"}))
+	assert.ErrorIs(t, service.Put(ctx, &counterObject{K: "c", Count: "1"}), ErrorWriteBufferFull)
+	assert.Equal(t, `{"k":"a","count":"2"}`, cache.values["a"])
+
+	assert.NoError(t, service.Flush(ctx))
+	assert.Equal(t, []map[string]string{{"a": "2", "b": "1"}}, db.puts)
+	n, err := buffer.Len(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+
+	// 缓冲区清空后允许追加，Close 时写入 db
+	assert.NoError(t, service.Put(ctx, &counterObject{K: "c", Count: "1"}))
+	service.Close()
+	assert.Equal(t, []map[string]string{{"a": "2", "b": "1"}, {"c": "1"}}, db.puts)
+}
+
+// 验证点：变更写入 db 之前，读流程不会将 db 中的旧数据写入缓存；写入 db 后撤销禁用
+func Test_WriteBehind_StaleFill(t *testing.T) {
+	buffer := &memoryWriteBuffer{maxLen: 10}
+	cache := newWriteBehindCache()
+	db := &mputRecordDB{}
+	service := newTestService(cache, db, WithWriteBehind(buffer, func() Object { return &counterObject{} }))
+	defer service.Close()
+	service.cancel()
+	service.wg.Wait()
+	ctx := context.Background()
+
+	assert.NoError(t, service.Put(ctx, &counterObject{K: "a", Count: "1"}))
+	assert.True(t, cache.disabled("a"))
+
+	// 缓存被淘汰后，读流程读取到 db 中的旧数据，但不允许写缓存
+	cache.mu.Lock()
+	delete(cache.values, "a")
+	cache.mu.Unlock()
+	obj := &counterObject{K: "a"}
+	_, err := service.Get(ctx, obj)
+	assert.NoError(t, err)
+	assert.Equal(t, "0", obj.Count)
+	assert.Empty(t, cache.get("a"))
+
+	// 写入 db 后撤销禁用，读流程重新写缓存
+	assert.NoError(t, service.Flush(ctx))
+	assert.Eventually(t, func() bool { return !cache.disabled("a") }, time.Second, 10*time.Millisecond)
+	_, err = service.Get(ctx, obj)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"k":"a","count":"1"}`, cache.get("a"))
+}
+
+// 按照执行顺序记录写操作的数据库模块
+type opRecordDB struct {
+	DB
+	mu  sync.Mutex
+	ops []string
+}
+
+func (d *opRecordDB) Delete(ctx context.Context, obj Object) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ops = append(d.ops, "del "+obj.Key())
+	return nil
+}
+
+func (d *opRecordDB) MPut(ctx context.Context, objs []Object) []error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, obj := range objs {
+		d.ops = append(d.ops, fmt.Sprintf("put %s=%s", obj.Key(), obj.(*counterObject).Count))
+	}
+	return make([]error, len(objs))
+}
+
+func (d *opRecordDB) records() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.ops...)
+}
+
+// 验证点：Del、MPut 同步写 db 之前先写回缓冲区中的变更，先前 Put 的变更不会在之后写入 db，使删除的数据重新出现或者覆盖 MPut 写入的数据
+func Test_WriteBehind_DelAndMPut(t *testing.T) {
+	buffer := &memoryWriteBuffer{maxLen: 10}
+	db := &opRecordDB{}
+	service := newTestService(newWriteBehindCache(), db, WithWriteBehind(buffer, func() Object { return &counterObject{} }))
+	service.cancel()
+	service.wg.Wait()
+	ctx := context.Background()
+
+	assert.NoError(t, service.Put(ctx, &counterObject{K: "a", Count: "1"}))
+	assert.NoError(t, service.Del(ctx, &counterObject{K: "a"}))
+	assert.NoError(t, service.Put(ctx, &counterObject{K: "b", Count: "1"}))
+	assert.Equal(t, []error{nil}, service.MPut(ctx, []Object{&counterObject{K: "b", Count: "2"}}))
+
+	// 缓冲区已经清空，Close 时不会再写入旧的变更
+	service.Close()
+	assert.Equal(t, []string{"put a=1", "del a", "put b=1", "put b=2"}, db.records())
+}
+
+type versionedCounterObject struct {
+	counterObject
+}
+
+func (o *versionedCounterObject) Version() int64 { return 1 }
+
+// 验证点：写回模式与 Versioned 接口的版本号相互冲突，构造时 panic
+func Test_WriteBehind_Versioned(t *testing.T) {
+	assert.Panics(t, func() {
+		newTestService(newWriteBehindCache(), &mputRecordDB{}, WithWriteBehind(&memoryWriteBuffer{}, func() Object { return &versionedCounterObject{} }))
+	})
+}
+
+// 验证点：写回模式未提供 newObject 时，构造时 panic 并给出明确的提示
+func Test_WriteBehind_NilNewObject(t *testing.T) {
+	assert.PanicsWithValue(t, "write behind mode requires newObject to decode buffered entries", func() {
+		newTestService(newWriteBehindCache(), &mputRecordDB{}, WithWriteBehind(&memoryWriteBuffer{}, nil))
+	})
+}
+
+// 续期时发现锁已经被其他实例持有的缓存模块
+type lostLockCache struct {
+	Cache
+}
+
+func (lostLockCache) Lock(ctx context.Context, key, token string, expireMilis int64) (bool, error) {
+	return false, nil
+}
+
+// 验证点：写回锁续期失败时终止写回
+func Test_keepLock(t *testing.T) {
+	service := newTestService(lostLockCache{}, &mputRecordDB{})
+	ctx, stop := service.keepLock(context.Background(), writeBehindLockKey, "token", 30)
+	defer stop()
+	assert.Eventually(t, func() bool { return ctx.Err() != nil }, time.Second, 10*time.Millisecond)
+}
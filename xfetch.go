@@ -0,0 +1,53 @@
+package consistent_cache
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// 读取缓存以及剩余过期时间. 剩余过期时间供提前刷新以及 GetResult 使用
+func (s *Service) getCache(ctx context.Context, key string) (v string, ttlMilis int64, err error) {
+	start := time.Now()
+	err = s.retry(ctx, func(ctx context.Context) (err error) {
+		v, ttlMilis, err = s.cache.GetWithTTL(ctx, key)
+		return err
+	})
+	getRecorderFrom(ctx).recordCache(time.Since(start), ttlMilis, err == nil)
+	return v, ttlMilis, err
+}
+
+// XFetch 算法：当 -delta * beta * ln(rand) >= 剩余过期时间 时提前刷新. delta 为观测到的加载耗时
+// 开启 stale-while-revalidate 时，以软过期时间作为过期时间
+func (s *Service) shouldRefreshEarly(ttlMilis int64) bool {
+	if s.opts.earlyRefreshBeta <= 0 || ttlMilis < 0 {
+		return false
+	}
+	delta := atomic.LoadInt64(&s.loadNanos)
+	if delta <= 0 {
+		return false
+	}
+
+	ttl := time.Duration(ttlMilis-s.opts.staleSeconds*1000) * time.Millisecond
+	gap := -float64(delta) * s.opts.earlyRefreshBeta * math.Log(1-rand.Float64())
+	return gap >= float64(ttl)
+}
+
+// 记录一次加载耗时. 使用指数加权移动平均，平滑单次加载耗时的抖动
+func (s *Service) observeLoad(cost time.Duration) {
+	if s.opts.earlyRefreshBeta <= 0 {
+		return
+	}
+	for {
+		old := atomic.LoadInt64(&s.loadNanos)
+		next := int64(cost)
+		if old > 0 {
+			next = old + (int64(cost)-old)/8
+		}
+		if atomic.CompareAndSwapInt64(&s.loadNanos, old, next) {
+			return
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package consistent_cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_shouldRefreshEarly(t *testing.T) {
+	service := &Service{opts: &Options{earlyRefreshBeta: 1}}
+	// 尚未观测到加载耗时
+	assert.False(t, service.shouldRefreshEarly(0))
+
+	service.observeLoad(100 * time.Millisecond)
+	assert.Equal(t, int64(100*time.Millisecond), service.loadNanos)
+	service.observeLoad(180 * time.Millisecond)
+	assert.Equal(t, int64(110*time.Millisecond), service.loadNanos)
+
+	// 剩余过期时间未知时不提前刷新，已经过期时必然提前刷新
+	assert.False(t, service.shouldRefreshEarly(-1))
+	assert.True(t, service.shouldRefreshEarly(0))
+
+	// 剩余过期时间远大于加载耗时，提前刷新的概率可以忽略
+	for i := 0; i < 1000; i++ {
+		assert.False(t, service.shouldRefreshEarly(time.Hour.Milliseconds()))
+	}
+}